@@ -0,0 +1,200 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/dhellmann/go-fork-diff/vcs"
+	"github.com/pkg/errors"
+)
+
+const cacheArgs = "list|verify|refresh|gc|prune [-days N] [-dry-run] [" + inputArgs + "]"
+
+// runCache maintains the repositories and module zips in the cache
+func runCache(opts *options, args []string) error {
+	if len(args) < 1 {
+		return errors.New("specify one of list, verify, refresh, gc or prune")
+	}
+	action, args := args[0], args[1:]
+	if action != "prune" && len(args) != 0 {
+		return fmt.Errorf("cache %s takes no arguments", action)
+	}
+
+	entries, err := vcs.ListCache(opts.workDir)
+	if err != nil {
+		return err
+	}
+
+	switch action {
+	case "list":
+		printCacheEntries(entries)
+		return nil
+	case "verify":
+		return verifyCache(opts, entries)
+	case "refresh":
+		return runParallel(opts.jobs, len(entries), func(i int) error {
+			return entries[i].Refresh(opts.verbose)
+		})
+	case "gc":
+		return runParallel(opts.jobs, len(entries), func(i int) error {
+			return entries[i].GC(opts.verbose)
+		})
+	case "prune":
+		return pruneCache(opts, entries, args)
+	}
+	return fmt.Errorf("unknown cache action %q", action)
+}
+
+// printCacheEntries shows the entries with their size and the times
+// they were last used and fetched
+func printCacheEntries(entries []*vcs.CacheEntry) {
+	width := len("PATH")
+	for _, entry := range entries {
+		if len(entry.Path) > width {
+			width = len(entry.Path)
+		}
+	}
+	const timeFormat = "2006-01-02 15:04"
+	fmt.Printf("%-*s  %-6s  %9s  %-16s  %-16s\n", width, "PATH", "KIND", "SIZE", "LAST USED", "LAST FETCH")
+	var total int64
+	for _, entry := range entries {
+		total += entry.Size
+		fmt.Printf("%-*s  %-6s  %9s  %-16s  %-16s\n", width, entry.Path, entry.Kind,
+			formatSize(entry.Size),
+			entry.LastUsed.Local().Format(timeFormat),
+			entry.LastFetch.Local().Format(timeFormat))
+	}
+	fmt.Printf("%d entries, %s\n", len(entries), formatSize(total))
+}
+
+// formatSize shows a number of bytes in the largest unit that keeps
+// it above one
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	value := float64(size) / unit
+	for _, suffix := range []string{"KiB", "MiB", "GiB"} {
+		if value < unit {
+			return fmt.Sprintf("%.1f %s", value, suffix)
+		}
+		value /= unit
+	}
+	return fmt.Sprintf("%.1f TiB", value)
+}
+
+// verifyCache checks every entry, reporting all of the broken ones
+// before failing
+func verifyCache(opts *options, entries []*vcs.CacheEntry) error {
+	problems := make([]error, len(entries))
+	err := runParallel(opts.jobs, len(entries), func(i int) error {
+		problems[i] = entries[i].Verify()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	broken := 0
+	for i, problem := range problems {
+		if problem != nil {
+			log.Printf("%s: %s", entries[i].Path, problem)
+			broken++
+		}
+	}
+	if broken > 0 {
+		return fmt.Errorf("%d of %d cache entries failed verification, remove them with prune or by hand", broken, len(entries))
+	}
+	log.Printf("verified %d cache entries", len(entries))
+	return nil
+}
+
+// pruneCache removes the entries that are not used by the input file,
+// when one is given, or that have not been used for a number of days
+func pruneCache(opts *options, entries []*vcs.CacheEntry, args []string) error {
+	flags := flag.NewFlagSet("cache prune", flag.ContinueOnError)
+	days := flags.Int("days", 0, "remove entries not used for this many days")
+	dryRun := flags.Bool("dry-run", false, "show what would be removed without removing it")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *days <= 0 && flags.NArg() == 0 {
+		return errors.New("cache prune needs -days, an input file, or both")
+	}
+
+	var used map[string]bool
+	if flags.NArg() > 0 {
+		used, err = usedCachePaths(opts, flags.Args())
+		if err != nil {
+			return err
+		}
+	}
+	cutoff := time.Now().AddDate(0, 0, -*days)
+
+	removed := 0
+	var freed int64
+	for _, entry := range entries {
+		var reason string
+		switch {
+		case used != nil && !used[entry.Path]:
+			reason = "not used by the input"
+		case *days > 0 && entry.LastUsed.Before(cutoff):
+			reason = fmt.Sprintf("not used since %s", entry.LastUsed.Local().Format("2006-01-02"))
+		default:
+			continue
+		}
+		if *dryRun {
+			log.Printf("%s: would remove, %s", entry.Path, reason)
+		} else {
+			log.Printf("%s: removing, %s", entry.Path, reason)
+			err = entry.Remove()
+			if err != nil {
+				return err
+			}
+		}
+		removed++
+		freed += entry.Size
+	}
+	verb := "removed"
+	if *dryRun {
+		verb = "would remove"
+	}
+	log.Printf("%s %d of %d cache entries, %s", verb, removed, len(entries), formatSize(freed))
+	return nil
+}
+
+// usedCachePaths returns the paths within the cache of the
+// repositories and module zips for the replacements in the input file
+func usedCachePaths(opts *options, args []string) (map[string]bool, error) {
+	in, err := readInput(opts, args)
+	if err != nil {
+		return nil, err
+	}
+
+	// Resolve the repositories even when comparing module zips, so
+	// the clones are kept as well.
+	resolveOpts := *opts
+	resolveOpts.proxies = nil
+	err = in.resolve(&resolveOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	used := map[string]bool{}
+	for _, repo := range in.repos {
+		if reason := repo.Unavailable(); reason != "" {
+			return nil, fmt.Errorf("cannot tell which cache entries %s uses, %s", repo.OldPath(), reason)
+		}
+		err = applyVersionRules(opts.cfg, repo, notCloned)
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range repo.CachePaths() {
+			used[path] = true
+		}
+	}
+	return used, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dhellmann/go-fork-diff/vcs"
+)
+
+func TestPruneCacheByAge(t *testing.T) {
+	workDir, err := ioutil.TempDir("", "go-fork-diff-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workDir)
+
+	oldZip := filepath.Join(workDir, "_cache", "proxy", "example.com", "x", "@v", "v1.0.0.zip")
+	newZip := filepath.Join(workDir, "_cache", "proxy", "example.com", "x", "@v", "v1.1.0.zip")
+	for _, filename := range []string{oldZip, newZip} {
+		err = os.MkdirAll(filepath.Dir(filename), 0755)
+		if err == nil {
+			err = ioutil.WriteFile(filename, []byte("zip"), 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	lastUsed := time.Now().AddDate(0, 0, -60)
+	err = os.Chtimes(oldZip, lastUsed, lastUsed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := &options{workDir: workDir, jobs: 1}
+	entries, err := vcs.ListCache(workDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pruneCache(opts, entries, nil); err == nil {
+		t.Errorf("expected an error without -days or an input file")
+	}
+
+	err = pruneCache(opts, entries, []string{"-days", "30", "-dry-run"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(oldZip); err != nil {
+		t.Errorf("dry run removed the zip: %s", err)
+	}
+
+	err = pruneCache(opts, entries, []string{"-days", "30"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(oldZip); !os.IsNotExist(err) {
+		t.Errorf("zip not used for 60 days was kept: %v", err)
+	}
+	if _, err := os.Stat(newZip); err != nil {
+		t.Errorf("zip used today was removed: %s", err)
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	for _, tc := range []struct {
+		size int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1536, "1.5 KiB"},
+		{5 << 20, "5.0 MiB"},
+		{3 << 40, "3.0 TiB"},
+	} {
+		if got := formatSize(tc.size); got != tc.want {
+			t.Errorf("formatSize(%d) = %q, want %q", tc.size, got, tc.want)
+		}
+	}
+}
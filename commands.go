@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/dhellmann/go-fork-diff/report"
+	"github.com/dhellmann/go-fork-diff/vcs"
+)
+
+// command is one of the modes of the program
+type command struct {
+	name string
+	args string
+	help string
+	run  func(opts *options, args []string) error
+}
+
+const inputArgs = "go-mod-file"
+
+var commands = []command{
+	{
+		name: "list",
+		args: inputArgs,
+		help: "list the replacements and the repositories they resolve to, without using git",
+		run:  runList,
+	},
+	{
+		name: "fetch",
+		args: inputArgs,
+		help: "populate the cache and local clones without reporting anything",
+		run:  runFetch,
+	},
+	{
+		name: "log",
+		args: inputArgs,
+		help: "show the commits in each fork",
+		run:  runLog,
+	},
+	{
+		name: "diffstat",
+		args: inputArgs,
+		help: "show the diff statistics for each fork",
+		run:  runDiffStat,
+	},
+	{
+		name: "diff",
+		args: inputArgs,
+		help: "show the full diff for each fork",
+		run:  runDiff,
+	},
+	{
+		name: "export-patches",
+		args: "output-dir " + inputArgs,
+		help: "write the commits in each fork as a patch series to a directory per\nmodule under output-dir, replacing any earlier export",
+		run:  runExportPatches,
+	},
+	{
+		name: "report",
+		args: inputArgs,
+		help: "show the commits and diff statistics for each fork (the default)",
+		run:  runReport,
+	},
+	{
+		name: "cache",
+		args: cacheArgs,
+		help: "maintain the cache in the working directory: list the repositories and\nmodule zips, verify or refresh them, pack them with git gc, or prune\nthe ones not used by the input file or for -days days",
+		run:  runCache,
+	},
+	{
+		name: "drift",
+		args: "repo-dir old-ref new-ref [go-mod-path]",
+		help: "compare the forks used by the repository in repo-dir at two refs,\nreading go-mod-path (default go.mod) at each ref",
+		run:  runDrift,
+	},
+}
+
+// findCommand returns the command with the name, or nil
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+// cloneInput reads, resolves and clones everything named by the input
+// file
+func cloneInput(opts *options, args []string) (*input, error) {
+	in, err := readInput(opts, args)
+	if err != nil {
+		return nil, err
+	}
+	err = in.resolve(opts)
+	if err != nil {
+		return nil, err
+	}
+	err = in.clone(opts)
+	if err != nil {
+		return nil, err
+	}
+	return in, nil
+}
+
+func printBanner(in *input, i int) {
+	fmt.Printf("\n------------------------------------------------------------\n%s\n  used by: %s\n------------------------------------------------------------\n\n",
+		in.repos[i].String(), strings.Join(in.replaces[i].Sources, ", "))
+}
+
+// forEachRepo clones the input and then shows each repository with
+// show
+func forEachRepo(opts *options, args []string, show func(repo *vcs.Repo) error) error {
+	in, err := cloneInput(opts, args)
+	if err != nil {
+		return err
+	}
+	for i, repo := range in.repos {
+		printBanner(in, i)
+		err = show(repo)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runList(opts *options, args []string) error {
+	in, err := readInput(opts, args)
+	if err != nil {
+		return err
+	}
+	err = in.resolve(opts)
+	if err != nil {
+		return err
+	}
+	for i, repo := range in.repos {
+		err = applyVersionRules(opts.cfg, repo, notCloned)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\n  used by: %s\n", repo.String(), strings.Join(in.replaces[i].Sources, ", "))
+	}
+	return nil
+}
+
+func runFetch(opts *options, args []string) error {
+	_, err := cloneInput(opts, args)
+	return err
+}
+
+func runLog(opts *options, args []string) error {
+	return forEachRepo(opts, args, (*vcs.Repo).Log)
+}
+
+func runDiffStat(opts *options, args []string) error {
+	return forEachRepo(opts, args, (*vcs.Repo).DiffStat)
+}
+
+func runDiff(opts *options, args []string) error {
+	return forEachRepo(opts, args, (*vcs.Repo).Diff)
+}
+
+func runReport(opts *options, args []string) error {
+	in, err := cloneInput(opts, args)
+	if err != nil {
+		return err
+	}
+
+	if opts.outputFormat != "text" {
+		result, err := report.Build(in.filename, in.repos, report.NeedsPatches(opts.outputFormat))
+		if err != nil {
+			return err
+		}
+		for i, fork := range result.Forks {
+			fork.UsedBy = in.replaces[i].Sources
+		}
+		result.AddConflicts(in.conflicts)
+		return report.Write(os.Stdout, opts.outputFormat, result)
+	}
+
+	for i, repo := range in.repos {
+		printBanner(in, i)
+		base, err := repo.NearestRelease()
+		if err != nil {
+			return err
+		}
+		if base != nil {
+			fmt.Printf("based on: %s\n\n", base)
+		}
+		err = repo.Log()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("\n\n")
+		err = repo.DiffStat()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
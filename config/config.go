@@ -0,0 +1,204 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dhellmann/go-fork-diff/vcs"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// DefaultFilename is the name of the configuration file looked for in
+// the working directory when no file is given explicitly.
+const DefaultFilename = "go-fork-diff.yaml"
+
+// Alias tells us to compare modules whose replacement path starts
+// with NewPrefix against OldRepo instead of the replaced module path
+type Alias struct {
+	NewPrefix string `yaml:"new-prefix"`
+	OldRepo   string `yaml:"old-repo"`
+}
+
+// Config holds the settings that control how replacements are
+// mapped back to their upstream versions
+type Config struct {
+	// Aliases maps fork module path prefixes to upstream repositories
+	Aliases []Alias `yaml:"aliases"`
+
+	// VersionSuffixes are regular expressions matching the part of a
+	// fork version added by the fork, such as "-k3s1". They are
+	// shorthand for version rules that strip the match and are tried
+	// before VersionRules.
+	VersionSuffixes []string `yaml:"version-suffixes"`
+
+	// VersionRules derive the upstream version from the fork version,
+	// in order
+	VersionRules []VersionRule `yaml:"version-rules"`
+
+	// FilterPrefixes limits the report to replacements with a new
+	// module path starting with one of the prefixes
+	FilterPrefixes []string `yaml:"filter-prefixes"`
+
+	// ForkBranches maps replaced module paths to the branch of the
+	// fork to compare when the fork version does not name a commit,
+	// instead of the default branch of the fork
+	ForkBranches map[string]string `yaml:"fork-branches"`
+
+	// filename is where the settings were loaded from
+	filename string
+
+	// rules holds the suffixes and version rules, compiled
+	rules []*VersionRule
+}
+
+// Default returns the settings used when there is no configuration
+// file
+func Default() *Config {
+	cfg := &Config{
+		Aliases: []Alias{
+			{
+				NewPrefix: "github.com/rancher/kubernetes/staging",
+				OldRepo:   "github.com/kubernetes/kubernetes",
+			},
+		},
+		VersionRules: []VersionRule{
+			{
+				Name:  "k3s",
+				Match: `-k3s\d$`,
+			},
+		},
+	}
+	// The defaults are known to be valid.
+	_ = cfg.validate()
+	return cfg
+}
+
+// Load reads and validates the configuration file
+func Load(filename string) (*Config, error) {
+	body, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read configuration file")
+	}
+
+	cfg := &Config{filename: filename}
+	err = yaml.UnmarshalStrict(body, cfg)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("could not parse %s", filename))
+	}
+
+	err = cfg.validate()
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("invalid configuration in %s", filename))
+	}
+	return cfg, nil
+}
+
+// Find loads the named configuration file or, if filename is empty,
+// the default file in workDir. If neither is present the default
+// settings are returned.
+func Find(filename, workDir string) (*Config, error) {
+	if filename != "" {
+		return Load(filename)
+	}
+
+	defaultFilename := filepath.Join(workDir, DefaultFilename)
+	_, err := os.Stat(defaultFilename)
+	if err == nil {
+		return Load(defaultFilename)
+	}
+	if !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "error checking for configuration file")
+	}
+	return Default(), nil
+}
+
+func (c *Config) validate() error {
+	for i, alias := range c.Aliases {
+		if alias.NewPrefix == "" {
+			return fmt.Errorf("alias %d has no new-prefix", i+1)
+		}
+		if alias.OldRepo == "" {
+			return fmt.Errorf("alias %d (%s) has no old-repo", i+1, alias.NewPrefix)
+		}
+	}
+
+	c.rules = nil
+	for _, suffix := range c.VersionSuffixes {
+		rule := &VersionRule{
+			Name:  fmt.Sprintf("suffix %s", suffix),
+			Match: suffix,
+		}
+		err := rule.compile()
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("bad version suffix %q", suffix))
+		}
+		c.rules = append(c.rules, rule)
+	}
+	for i := range c.VersionRules {
+		rule := &c.VersionRules[i]
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		err := rule.compile()
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("bad version rule %q", rule.Name))
+		}
+		c.rules = append(c.rules, rule)
+	}
+
+	for i, prefix := range c.FilterPrefixes {
+		if prefix == "" {
+			return fmt.Errorf("filter prefix %d is empty", i+1)
+		}
+	}
+
+	for modulePath, branch := range c.ForkBranches {
+		if branch == "" {
+			return fmt.Errorf("fork branch for %s is empty", modulePath)
+		}
+	}
+
+	return nil
+}
+
+// Filename returns the name of the file the settings were loaded
+// from, or an empty string for the defaults
+func (c *Config) Filename() string {
+	return c.filename
+}
+
+// RepoAliases returns the aliases in the form used by the vcs package
+func (c *Config) RepoAliases() []vcs.Alias {
+	result := make([]vcs.Alias, 0, len(c.Aliases))
+	for _, alias := range c.Aliases {
+		result = append(result, vcs.Alias{
+			NewPrefix: alias.NewPrefix,
+			OldRepo:   alias.OldRepo,
+		})
+	}
+	return result
+}
+
+// ForkBranch returns the branch of the fork to compare for the
+// replaced module, or an empty string to use the default branch
+func (c *Config) ForkBranch(oldPath string) string {
+	return c.ForkBranches[oldPath]
+}
+
+// Include reports whether a replacement with the new module path
+// passes the filter prefixes
+func (c *Config) Include(newPath string) bool {
+	if len(c.FilterPrefixes) == 0 {
+		return true
+	}
+	for _, prefix := range c.FilterPrefixes {
+		if strings.HasPrefix(newPath, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/dhellmann/go-fork-diff/vcs"
+)
+
+// writeConfig creates a configuration file with the body in a new
+// temporary directory and returns the directory
+func writeConfig(t *testing.T, body string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "config-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	err = ioutil.WriteFile(filepath.Join(dir, DefaultFilename), []byte(body), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestLoad(t *testing.T) {
+	dir := writeConfig(t, `
+aliases:
+  - new-prefix: example.com/fork/staging
+    old-repo: example.com/upstream
+version-suffixes:
+  - -fork\d+$
+filter-prefixes:
+  - example.com/fork
+`)
+	filename := filepath.Join(dir, DefaultFilename)
+
+	cfg, err := Load(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Filename() != filename {
+		t.Errorf("filename %s, want %s", cfg.Filename(), filename)
+	}
+	want := []vcs.Alias{{NewPrefix: "example.com/fork/staging", OldRepo: "example.com/upstream"}}
+	if got := cfg.RepoAliases(); !reflect.DeepEqual(got, want) {
+		t.Errorf("aliases %v, want %v", got, want)
+	}
+	if !cfg.Include("example.com/fork/x") {
+		t.Errorf("example.com/fork/x is filtered out")
+	}
+	if cfg.Include("example.com/other/x") {
+		t.Errorf("example.com/other/x is not filtered out")
+	}
+	if len(cfg.rules) != 1 || cfg.rules[0].Name != `suffix -fork\d+$` {
+		t.Errorf("rules %v, want the version suffix", cfg.rules)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		body string
+	}{
+		{"unknown field", "alias: []\n"},
+		{"no new prefix", "aliases:\n  - old-repo: example.com/upstream\n"},
+		{"no old repo", "aliases:\n  - new-prefix: example.com/fork\n"},
+		{"bad suffix", "version-suffixes:\n  - \"(\"\n"},
+		{"empty filter", "filter-prefixes:\n  - \"\"\n"},
+	} {
+		dir := writeConfig(t, tc.body)
+		if _, err := Load(filepath.Join(dir, DefaultFilename)); err == nil {
+			t.Errorf("%s: expected an error", tc.name)
+		}
+	}
+}
+
+func TestFind(t *testing.T) {
+	dir := writeConfig(t, "filter-prefixes:\n  - example.com/fork\n")
+
+	cfg, err := Find("", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Filename() != filepath.Join(dir, DefaultFilename) {
+		t.Errorf("found %q, want the file in %s", cfg.Filename(), dir)
+	}
+
+	empty, err := ioutil.TempDir("", "config-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(empty)
+	cfg, err = Find("", empty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Filename() != "" || !reflect.DeepEqual(cfg.RepoAliases(), Default().RepoAliases()) {
+		t.Errorf("found %q with aliases %v, want the defaults", cfg.Filename(), cfg.RepoAliases())
+	}
+
+	if _, err := Find(filepath.Join(empty, "missing.yaml"), dir); err == nil {
+		t.Errorf("expected an error for a missing file")
+	}
+}
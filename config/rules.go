@@ -0,0 +1,147 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/dhellmann/go-fork-diff/vcs"
+	"github.com/pkg/errors"
+)
+
+const (
+	// SourceVersion rules are matched against the fork version
+	SourceVersion = "version"
+
+	// SourceTagMessage rules are matched against the message of the
+	// annotated tag for the fork version in the fork repository
+	SourceTagMessage = "tag-message"
+)
+
+// VersionRule derives the upstream version of a module from the
+// version of its fork
+type VersionRule struct {
+	// Name identifies the rule in the report
+	Name string `yaml:"name"`
+
+	// Module is an optional regular expression the replaced module
+	// path must match for the rule to apply
+	Module string `yaml:"module"`
+
+	// Match is the regular expression applied to the source text
+	Match string `yaml:"match"`
+
+	// Replace is the template used to build the upstream version,
+	// using $1 or ${name} to refer to submatches. For version rules
+	// every match in the fork version is replaced, so an empty
+	// template strips the match. For tag message rules the template
+	// is expanded from the first match, and an empty template uses
+	// the whole match.
+	Replace string `yaml:"replace"`
+
+	// Source is either "version" (the default) or "tag-message"
+	Source string `yaml:"source"`
+
+	moduleMatcher *regexp.Regexp
+	matcher       *regexp.Regexp
+}
+
+func (r *VersionRule) compile() error {
+	if r.Match == "" {
+		return errors.New("no match expression")
+	}
+
+	switch r.Source {
+	case "":
+		r.Source = SourceVersion
+	case SourceVersion, SourceTagMessage:
+	default:
+		return fmt.Errorf("unknown source %q", r.Source)
+	}
+
+	var err error
+	if r.Module != "" {
+		r.moduleMatcher, err = regexp.Compile(r.Module)
+		if err != nil {
+			return errors.Wrap(err, "bad module expression")
+		}
+	}
+	r.matcher, err = regexp.Compile(r.Match)
+	if err != nil {
+		return errors.Wrap(err, "bad match expression")
+	}
+	return nil
+}
+
+// appliesTo reports whether the rule should be tried for the module
+func (r *VersionRule) appliesTo(modulePath string) bool {
+	return r.moduleMatcher == nil || r.moduleMatcher.MatchString(modulePath)
+}
+
+// apply returns the upstream version derived from text and whether
+// the rule matched
+func (r *VersionRule) apply(text string) (string, bool) {
+	if r.Source == SourceTagMessage {
+		match := r.matcher.FindStringSubmatchIndex(text)
+		if match == nil {
+			return "", false
+		}
+		if r.Replace == "" {
+			return text[match[0]:match[1]], true
+		}
+		return string(r.matcher.ExpandString(nil, r.Replace, text, match)), true
+	}
+
+	if !r.matcher.MatchString(text) {
+		return "", false
+	}
+	return r.matcher.ReplaceAllString(text, r.Replace), true
+}
+
+// TagMessageFunc returns the message of the annotated tag for the
+// fork version. An error caused by vcs.ErrNoTagMessage means there is
+// no such tag.
+type TagMessageFunc func() (string, error)
+
+// UpstreamVersion applies the version rules in order and returns the
+// upstream version given by the first one to match, along with that
+// rule. If no rule matches the rule is nil. The tag message is only
+// requested when a tag message rule applies to the module, and when
+// the fork version has no annotated tag those rules do not match.
+func (c *Config) UpstreamVersion(modulePath, forkVersion string, tagMessage TagMessageFunc) (string, *VersionRule, error) {
+	var (
+		message     string
+		haveMessage bool
+		noMessage   bool
+	)
+
+	for _, rule := range c.rules {
+		if !rule.appliesTo(modulePath) {
+			continue
+		}
+
+		text := forkVersion
+		if rule.Source == SourceTagMessage {
+			if !haveMessage {
+				var err error
+				message, err = tagMessage()
+				if errors.Cause(err) == vcs.ErrNoTagMessage {
+					noMessage = true
+				} else if err != nil {
+					return "", nil, errors.Wrap(err,
+						fmt.Sprintf("could not read tag message for rule %q", rule.Name))
+				}
+				haveMessage = true
+			}
+			if noMessage {
+				continue
+			}
+			text = message
+		}
+
+		if version, ok := rule.apply(text); ok && version != "" {
+			return version, rule, nil
+		}
+	}
+
+	return "", nil, nil
+}
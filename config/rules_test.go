@@ -0,0 +1,104 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/dhellmann/go-fork-diff/vcs"
+	"github.com/pkg/errors"
+)
+
+func TestUpstreamVersion(t *testing.T) {
+	cfg := &Config{
+		VersionSuffixes: []string{`-fork\d+$`},
+		VersionRules: []VersionRule{
+			{
+				Module:  `^example\.com/tagged/`,
+				Match:   `upstream (v\S+)`,
+				Replace: "$1",
+				Source:  SourceTagMessage,
+			},
+			{
+				Name:    "rewrite",
+				Match:   `^v(\d+)\.(\d+)\.(\d+)-rc$`,
+				Replace: "v$1.$2.$3",
+			},
+		},
+	}
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		module   string
+		version  string
+		message  string
+		noTag    bool
+		want     string
+		wantRule string
+	}{
+		{"suffix", "example.com/x", "v1.2.3-fork1", "", false, "v1.2.3", `suffix -fork\d+$`},
+		{"suffix first", "example.com/tagged/x", "v1.2.3-fork1", "upstream v1.0.0", false, "v1.2.3", `suffix -fork\d+$`},
+		{"tag message", "example.com/tagged/x", "v1.2.3-rc", "based on upstream v1.0.0\n", false, "v1.0.0", "rule 1"},
+		{"module does not match", "example.com/x", "v1.2.3", "upstream v1.0.0", false, "", ""},
+		{"no tag", "example.com/tagged/x", "v1.2.3-rc", "", true, "v1.2.3", "rewrite"},
+		{"no rule", "example.com/x", "v1.2.3", "", false, "", ""},
+	} {
+		asked := false
+		tagMessage := func() (string, error) {
+			asked = true
+			if tc.noTag {
+				return "", errors.Wrap(vcs.ErrNoTagMessage, "no tag")
+			}
+			return tc.message, nil
+		}
+
+		version, rule, err := cfg.UpstreamVersion(tc.module, tc.version, tagMessage)
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			continue
+		}
+		ruleName := ""
+		if rule != nil {
+			ruleName = rule.Name
+		}
+		if version != tc.want || ruleName != tc.wantRule {
+			t.Errorf("%s: got %q from %q, want %q from %q", tc.name, version, ruleName, tc.want, tc.wantRule)
+		}
+		if asked && tc.module != "example.com/tagged/x" {
+			t.Errorf("%s: read the tag message for a module no tag message rule applies to", tc.name)
+		}
+	}
+}
+
+func TestUpstreamVersionTagMessageError(t *testing.T) {
+	cfg := &Config{
+		VersionRules: []VersionRule{{Match: "v.*", Source: SourceTagMessage}},
+	}
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err := cfg.UpstreamVersion("example.com/x", "v1.0.0", func() (string, error) {
+		return "", errors.New("broken")
+	})
+	if err == nil {
+		t.Errorf("expected an error")
+	}
+}
+
+func TestValidateRules(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		rule VersionRule
+	}{
+		{"no match", VersionRule{Name: "empty"}},
+		{"unknown source", VersionRule{Match: "x", Source: "branch"}},
+		{"bad module", VersionRule{Match: "x", Module: "("}},
+		{"bad match", VersionRule{Match: "("}},
+	} {
+		cfg := &Config{VersionRules: []VersionRule{tc.rule}}
+		if err := cfg.validate(); err == nil {
+			t.Errorf("%s: expected an error", tc.name)
+		}
+	}
+}
@@ -33,43 +33,81 @@ func urlForImportPath(importPath string) (*urlpkg.URL, error) {
 	return &urlpkg.URL{Scheme: "https", Host: host, Path: path, RawQuery: "go-get=1"}, nil
 }
 
+// RepoRoot describes the repository holding an import path
+type RepoRoot struct {
+	// Root is the URL of the repository
+	Root string
+
+	// Prefix is the import path corresponding to the root of the
+	// repository
+	Prefix string
+
+	// VCS is the version control system used by the repository
+	VCS string
+
+	// Source holds the go-source settings for browsing the
+	// repository, if the server provided them
+	Source *Source
+}
+
+// Source represents the parsed <meta name="go-source"
+// content="prefix home directory file" /> tag. The directory and file
+// values are templates using the {dir}, {/dir}, {file}, {line} and
+// {#line} placeholders.
+type Source struct {
+	Prefix    string
+	Home      string
+	Directory string
+	File      string
+}
+
 // RepoRootForImportDynamic finds a repository root for a custom domain
 // This handles custom import paths like "name.tld/pkg/foo" or just "name.tld".
 func RepoRootForImportDynamic(importPath string) (string, error) {
-	url, err := urlForImportPath(importPath)
+	root, err := RepoForImportDynamic(importPath)
 	if err != nil {
 		return "", err
 	}
+	return root.Root, nil
+}
+
+// RepoForImportDynamic is like RepoRootForImportDynamic but returns
+// everything the server told us about the repository.
+func RepoForImportDynamic(importPath string) (*RepoRoot, error) {
+	url, err := urlForImportPath(importPath)
+	if err != nil {
+		return nil, err
+	}
 
 	client := http.Client{
 		Timeout: time.Second * 20,
 	}
 	req, err := http.NewRequest(http.MethodGet, url.String(), nil)
 	if err != nil {
-		return "", errors.Wrap(err, "unable to build request")
+		return nil, errors.Wrap(err, "unable to build request")
 	}
 	req.Header.Set("User-Agent", "go-fork-diff")
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", errors.Wrap(err, "unable to fetch request")
+		return nil, errors.Wrap(err, "unable to fetch request")
 	}
 
 	body := resp.Body
 	defer body.Close()
-	imports, err := parseMetaGoImports(body)
+	imports, sources, err := parseMetaGoImports(body)
 	if err != nil {
-		return "", errors.Wrap(err, "could not get meta tag for import instructions")
+		return nil, errors.Wrap(err, "could not get meta tag for import instructions")
 	}
 	if len(imports) == 0 {
-		return "", errors.New("no import instructions found for import path")
+		return nil, errors.New("no import instructions found for import path")
 	}
 	// Find the matched meta import.
 	mmi, err := matchGoImport(imports, importPath)
 	if err != nil {
 		if _, ok := err.(ImportMismatchError); !ok {
-			return "", fmt.Errorf("parse %s: %v", url, err)
+			return nil, fmt.Errorf("parse %s: %v", url, err)
 		}
-		return "", fmt.Errorf("parse %s: no go-import meta tags (%s)", url, err)
+		return nil, fmt.Errorf("parse %s: no go-import meta tags (%s)", url, err)
 	}
 	// If the import was "uni.edu/bob/project", which said the
 	// prefix was "uni.edu" and the RepoRoot was "evilroot.com",
@@ -81,20 +119,32 @@ func RepoRootForImportDynamic(importPath string) (string, error) {
 		var imports []metaImport
 		url2, imports, err := metaImportsForPrefix(mmi.Prefix)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 		metaImport2, err := matchGoImport(imports, importPath)
 		if err != nil || mmi != metaImport2 {
-			return "", fmt.Errorf("%s and %s disagree about go-import for %s", url, url2,
+			return nil, fmt.Errorf("%s and %s disagree about go-import for %s", url, url2,
 				mmi.Prefix)
 		}
 	}
 
 	if err := validateRepoRoot(mmi.RepoRoot); err != nil {
-		return "", fmt.Errorf("%s: invalid repo root %q: %v", url, mmi.RepoRoot, err)
+		return nil, fmt.Errorf("%s: invalid repo root %q: %v", url, mmi.RepoRoot, err)
 	}
 
-	return mmi.RepoRoot, nil
+	root := &RepoRoot{
+		Root:   mmi.RepoRoot,
+		Prefix: mmi.Prefix,
+		VCS:    mmi.VCS,
+	}
+	for _, source := range sources {
+		if pathPrefix(importPath, source.Prefix) {
+			source := source
+			root.Source = &source
+			break
+		}
+	}
+	return root, nil
 }
 
 // validateRepoRoot returns an error if repoRoot does not seem to be
@@ -146,7 +196,7 @@ func metaImportsForPrefix(importPrefix string) (*urlpkg.URL, []metaImport, error
 	}
 	body := resp.Body
 	defer body.Close()
-	imports, err := parseMetaGoImports(body)
+	imports, _, err := parseMetaGoImports(body)
 	if len(imports) == 0 {
 		return nil, nil, errors.Wrap(err, "found no import instructions")
 	}
@@ -237,18 +287,20 @@ func charsetReader(charset string, input io.Reader) (io.Reader, error) {
 	}
 }
 
-// parseMetaGoImports returns meta imports from the HTML in r.
+// parseMetaGoImports returns meta imports and go-source settings
+// from the HTML in r.
 // Parsing ends at the end of the <head> section or the beginning of the <body>.
-func parseMetaGoImports(r io.Reader) ([]metaImport, error) {
+func parseMetaGoImports(r io.Reader) ([]metaImport, []Source, error) {
 	d := xml.NewDecoder(r)
 	d.CharsetReader = charsetReader
 	d.Strict = false
 	var imports []metaImport
+	var sources []Source
 	for {
 		t, err := d.RawToken()
 		if err != nil {
 			if err != io.EOF && len(imports) == 0 {
-				return nil, err
+				return nil, nil, err
 			}
 			break
 		}
@@ -262,6 +314,17 @@ func parseMetaGoImports(r io.Reader) ([]metaImport, error) {
 		if !ok || !strings.EqualFold(e.Name.Local, "meta") {
 			continue
 		}
+		if attrValue(e.Attr, "name") == "go-source" {
+			if f := strings.Fields(attrValue(e.Attr, "content")); len(f) == 4 {
+				sources = append(sources, Source{
+					Prefix:    f[0],
+					Home:      f[1],
+					Directory: f[2],
+					File:      f[3],
+				})
+			}
+			continue
+		}
 		if attrValue(e.Attr, "name") != "go-import" {
 			continue
 		}
@@ -294,7 +357,7 @@ func parseMetaGoImports(r io.Reader) ([]metaImport, error) {
 			list = append(list, m)
 		}
 	}
-	return list, nil
+	return list, sources, nil
 }
 
 // attrValue returns the attribute value for the case-insensitive key
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/dhellmann/go-fork-diff/gomod"
+	"github.com/dhellmann/go-fork-diff/vcs"
+	"github.com/pkg/errors"
+	"golang.org/x/mod/module"
+)
+
+// forkChange describes a module replaced by a different fork or
+// version at the new ref
+type forkChange struct {
+	before *gomod.Replacement
+	after  *gomod.Replacement
+
+	// repo compares the fork at the old ref with the fork at the new
+	// ref, when both are versioned modules
+	repo *vcs.Repo
+
+	added   []vcs.Commit
+	removed []vcs.Commit
+}
+
+// runDrift compares the forks used by a repository at two refs
+func runDrift(opts *options, args []string) error {
+	if len(args) < 3 || len(args) > 4 {
+		return errors.New("drift needs a repository directory, two refs, and optionally the path to go.mod within the repository")
+	}
+	repoDir, oldRef, newRef := args[0], args[1], args[2]
+	modPath := "go.mod"
+	if len(args) == 4 {
+		modPath = args[3]
+	}
+
+	before, err := readModAtRef(opts, repoDir, oldRef, modPath)
+	if err != nil {
+		return err
+	}
+	after, err := readModAtRef(opts, repoDir, newRef, modPath)
+	if err != nil {
+		return err
+	}
+
+	var (
+		added   []*gomod.Replacement
+		dropped []*gomod.Replacement
+		changed []*forkChange
+	)
+	for _, a := range after {
+		b, ok := before[a.Old]
+		if !ok {
+			added = append(added, a)
+			continue
+		}
+		if b.New.Version == a.New.Version && b.New.Path == a.New.Path &&
+			b.LocalDir() == a.LocalDir() {
+			continue
+		}
+		changed = append(changed, &forkChange{before: b, after: a})
+	}
+	for _, b := range before {
+		if _, ok := after[b.Old]; !ok {
+			dropped = append(dropped, b)
+		}
+	}
+	sortReplacements(added)
+	sortReplacements(dropped)
+	sortChanges(changed)
+
+	err = runParallel(opts.jobs, len(changed), func(i int) error {
+		return changed[i].compare(opts)
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Fork drift in %s between %s and %s\n",
+		filepath.Join(repoDir, modPath), oldRef, newRef)
+
+	fmt.Printf("\nNew forks (%d):\n", len(added))
+	for _, r := range added {
+		fmt.Printf("  %s => %s\n", r.Old.Path, describeTarget(r))
+	}
+
+	fmt.Printf("\nDropped forks (%d):\n", len(dropped))
+	for _, r := range dropped {
+		fmt.Printf("  %s => %s\n", r.Old.Path, describeTarget(r))
+	}
+
+	fmt.Printf("\nChanged forks (%d):\n", len(changed))
+	for _, c := range changed {
+		fmt.Printf("  %s\n    was: %s\n    now: %s\n",
+			c.before.Old.Path, describeTarget(c.before), describeTarget(c.after))
+		if c.repo == nil {
+			fmt.Printf("    (cannot compare commits for local directories)\n")
+			continue
+		}
+		if reason := c.repo.Unavailable(); reason != "" {
+			fmt.Printf("    (%s)\n", reason)
+			continue
+		}
+		fmt.Printf("    commits added (%d):\n", len(c.added))
+		printCommits(c.added)
+		fmt.Printf("    commits removed (%d):\n", len(c.removed))
+		printCommits(c.removed)
+	}
+
+	return nil
+}
+
+// readModAtRef returns the replacements in the go.mod file at ref that
+// pass the filters
+func readModAtRef(opts *options, repoDir, ref, modPath string) (map[module.Version]*gomod.Replacement, error) {
+	body, err := vcs.ShowFile(opts.backend, repoDir, ref, modPath)
+	if err != nil {
+		return nil, err
+	}
+	replacements, err := gomod.ParseModFile(fmt.Sprintf("%s:%s", ref, modPath), body)
+	if err != nil {
+		return nil, err
+	}
+
+	// Relative directories are relative to the location of go.mod in
+	// the working tree, since that is what we can look at.
+	dir := filepath.Dir(filepath.Join(repoDir, modPath))
+	result := map[module.Version]*gomod.Replacement{}
+	for _, r := range replacements {
+		if !opts.cfg.Include(r.New.Path) {
+			continue
+		}
+		r.Dir = dir
+		result[r.Old] = r
+	}
+	return result, nil
+}
+
+// compare clones the old and new forks and finds the commits between
+// them
+func (c *forkChange) compare(opts *options) error {
+	if c.before.New.Version == "" || c.after.New.Version == "" {
+		return nil
+	}
+
+	repo, err := vcs.New(
+		opts.workDir,
+		c.before.New.Path,
+		c.before.New.Version,
+		c.after.New.Path,
+		c.after.New.Version,
+		nil,
+	)
+	if err != nil {
+		return errors.Wrap(err, c.before.Old.Path)
+	}
+	repo.SetBackend(opts.backend)
+	err = repo.Clone(opts.verbose)
+	if err != nil {
+		return errors.Wrap(err, c.before.Old.Path)
+	}
+
+	c.added, err = repo.Commits()
+	if err != nil {
+		return errors.Wrap(err, c.before.Old.Path)
+	}
+	c.removed, err = repo.DroppedCommits()
+	if err != nil {
+		return errors.Wrap(err, c.before.Old.Path)
+	}
+	c.repo = repo
+	return nil
+}
+
+func sortReplacements(replacements []*gomod.Replacement) {
+	sort.Slice(replacements, func(i, j int) bool {
+		return replacements[i].Old.String() < replacements[j].Old.String()
+	})
+}
+
+func sortChanges(changes []*forkChange) {
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].before.Old.String() < changes[j].before.Old.String()
+	})
+}
+
+func describeTarget(r *gomod.Replacement) string {
+	if r.New.Version == "" {
+		return r.New.Path
+	}
+	return fmt.Sprintf("%s %s", r.New.Path, r.New.Version)
+}
+
+func printCommits(commits []vcs.Commit) {
+	for _, commit := range commits {
+		// Other version control systems may have shorter
+		// identifiers, such as svn revision numbers.
+		hash := commit.Hash
+		if len(hash) > 12 {
+			hash = hash[:12]
+		}
+		fmt.Printf("      %s %s %s\n",
+			hash, commit.Date.Format("2006-01-02 15:04:05 -0700"), commit.Subject)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dhellmann/go-fork-diff/vcs"
+	"github.com/pkg/errors"
+)
+
+const (
+	seriesFilename = "series"
+	readmeFilename = "README"
+)
+
+func runExportPatches(opts *options, args []string) error {
+	if len(args) < 1 {
+		return errors.New("specify the directory to write the patches to")
+	}
+	outputRoot := args[0]
+
+	in, err := cloneInput(opts, args[1:])
+	if err != nil {
+		return err
+	}
+
+	for i, repo := range in.repos {
+		err = exportPatches(outputRoot, repo, in.replaces[i].Sources)
+		if err != nil {
+			return errors.Wrap(err, repo.OldPath())
+		}
+	}
+	return nil
+}
+
+// exportPatches writes the fork commits for one module to a directory
+// named for the module under outputRoot, replacing anything written
+// there before
+func exportPatches(outputRoot string, repo *vcs.Repo, usedBy []string) error {
+	dir := filepath.Join(outputRoot, filepath.FromSlash(repo.OldPath()))
+
+	if reason := repo.Unavailable(); reason != "" {
+		log.Printf("%s: not exporting patches, %s", repo.OldPath(), reason)
+		return nil
+	}
+	if !repo.HasGitHistory() {
+		log.Printf("%s: no git history to export patches from", repo.OldPath())
+		return nil
+	}
+
+	err := os.RemoveAll(dir)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("could not remove %s", dir))
+	}
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("could not create %s", dir))
+	}
+
+	mergeBase := repo.MergeBase()
+	if mergeBase == "" {
+		log.Printf("%s: no common ancestor, not exporting patches", repo.OldPath())
+	}
+
+	names, err := repo.FormatPatches(dir)
+	if err != nil {
+		return err
+	}
+	log.Printf("%s: wrote %d patches to %s", repo.OldPath(), len(names), dir)
+
+	series := ""
+	if len(names) > 0 {
+		series = strings.Join(names, "\n") + "\n"
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, seriesFilename), []byte(series), 0644)
+	if err != nil {
+		return errors.Wrap(err, "could not write series file")
+	}
+
+	var readme strings.Builder
+	fmt.Fprintf(&readme, "Patches carried by %s @ %s\n\n", repo.NewPath(), repo.NewVersion())
+	fmt.Fprintf(&readme, "base: %s @ %s\n", repo.OldPath(), repo.OldVersion())
+	if repo.OldVersionSource() != "" {
+		fmt.Fprintf(&readme, "base version from: %s\n", repo.OldVersionSource())
+	}
+	fmt.Fprintf(&readme, "upstream repository: %s\n", repo.OldRepo())
+	fmt.Fprintf(&readme, "fork repository: %s\n", repo.NewRepo())
+	if mergeBase != "" {
+		fmt.Fprintf(&readme, "merge base: %s\n", mergeBase)
+	}
+	if len(usedBy) > 0 {
+		fmt.Fprintf(&readme, "used by: %s\n", strings.Join(usedBy, ", "))
+	}
+	fmt.Fprintf(&readme, "\n%d patches, listed in order in %s.\n", len(names), seriesFilename)
+	if len(names) > 0 {
+		fmt.Fprintf(&readme, "Apply them to a checkout of the base version with:\n\n")
+		fmt.Fprintf(&readme, "    git am $(sed 's|^|%s/|' %s/%s)\n", dir, dir, seriesFilename)
+	} else if mergeBase == "" {
+		fmt.Fprintf(&readme, "The fork has no common ancestor with the base version.\n")
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, readmeFilename), []byte(readme.String()), 0644)
+	if err != nil {
+		return errors.Wrap(err, "could not write README")
+	}
+	return nil
+}
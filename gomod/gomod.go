@@ -0,0 +1,150 @@
+package gomod
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// Replacement is one effective replace directive
+type Replacement struct {
+	// Old is the module being replaced. The version is empty when
+	// the directive applies to every version.
+	Old module.Version
+
+	// New is the replacement module. The version is empty when the
+	// path is a local directory.
+	New module.Version
+
+	// RequiredVersion is the version of Old.Path the module requires,
+	// if it requires it directly
+	RequiredVersion string
+
+	// Sources are the files containing the replace directive
+	Sources []string
+
+	// Dir is the directory relative paths in New are relative to
+	Dir string
+
+	// requiredFrom describes where RequiredVersion came from, when it
+	// is not a require directive
+	requiredFrom string
+}
+
+// UpstreamVersion returns the version of the module being replaced,
+// either from the replace directive itself or from the require
+// directive, and a description of where it came from
+func (r *Replacement) UpstreamVersion() (string, string) {
+	if r.Old.Version != "" {
+		return r.Old.Version, "replace directive"
+	}
+	if r.requiredFrom != "" {
+		return r.RequiredVersion, r.requiredFrom
+	}
+	return r.RequiredVersion, "require directive"
+}
+
+// Read parses a go.mod, go.work or vendor/modules.txt file and returns
+// the effective replacements it declares. If filename is a directory, every go.mod
+// file in the tree is read.
+func Read(filename string) ([]*Replacement, error) {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read input")
+	}
+	if info.IsDir() {
+		return ScanTree(filename)
+	}
+	if filepath.Ext(filename) == ".work" {
+		return ReadWorkFile(filename)
+	}
+	if IsVendorFile(filename) {
+		return ReadVendorFile(filename)
+	}
+	return ReadModFile(filename)
+}
+
+// ReadModFile returns the replacements declared in a go.mod file
+func ReadModFile(filename string) ([]*Replacement, error) {
+	mod, err := parseModFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return modReplacements(filename, mod), nil
+}
+
+// ParseModFile returns the replacements declared in the contents of
+// a go.mod file. The filename is used in messages and to find local
+// directories.
+func ParseModFile(filename string, body []byte) ([]*Replacement, error) {
+	mod, err := modfile.Parse(filename, body, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("could not parse %s", filename))
+	}
+	return modReplacements(filename, mod), nil
+}
+
+func modReplacements(filename string, mod *modfile.File) []*Replacement {
+	required := requiredVersions(nil, mod)
+	result := make([]*Replacement, 0, len(mod.Replace))
+	for _, replace := range mod.Replace {
+		result = append(result, newReplacement(filename, replace, required))
+	}
+	return result
+}
+
+func parseModFile(filename string) (*modfile.File, error) {
+	body, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read module file")
+	}
+	mod, err := modfile.Parse(filename, body, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("could not parse %s", filename))
+	}
+	return mod, nil
+}
+
+// requiredVersions adds the versions required by the module to
+// required, keeping the highest version of each module
+func requiredVersions(required map[string]string, mod *modfile.File) map[string]string {
+	if required == nil {
+		required = map[string]string{}
+	}
+	for _, req := range mod.Require {
+		if current, ok := required[req.Mod.Path]; ok &&
+			semver.Compare(current, req.Mod.Version) >= 0 {
+			continue
+		}
+		required[req.Mod.Path] = req.Mod.Version
+	}
+	return required
+}
+
+func newReplacement(filename string, replace *modfile.Replace, required map[string]string) *Replacement {
+	return &Replacement{
+		Old:             replace.Old,
+		New:             replace.New,
+		RequiredVersion: required[replace.Old.Path],
+		Sources:         []string{filename},
+		Dir:             filepath.Dir(filename),
+	}
+}
+
+// LocalDir returns the directory for a replacement with a filesystem
+// path, or an empty string if the replacement is a module
+func (r *Replacement) LocalDir() string {
+	if !modfile.IsDirectoryPath(r.New.Path) {
+		return ""
+	}
+	if filepath.IsAbs(r.New.Path) {
+		return filepath.Clean(r.New.Path)
+	}
+	return filepath.Join(r.Dir, r.New.Path)
+}
@@ -0,0 +1,139 @@
+package gomod
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/mod/semver"
+)
+
+// ScanTree reads the replacements from every go.mod file under root.
+// Like the go command, it skips vendor and testdata directories and
+// directories starting with "." or "_".
+func ScanTree(root string) ([]*Replacement, error) {
+	result := []*Replacement{}
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			name := info.Name()
+			if path != root && (name == "vendor" || name == "testdata" ||
+				strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Name() != "go.mod" {
+			return nil
+		}
+		replacements, err := ReadModFile(path)
+		if err != nil {
+			return err
+		}
+		result = append(result, replacements...)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("could not scan %s", root))
+	}
+	return result, nil
+}
+
+// target identifies the fork a module is replaced with
+type target struct {
+	oldPath    string
+	newPath    string
+	newVersion string
+}
+
+func (r *Replacement) target() target {
+	newPath := r.New.Path
+	if dir := r.LocalDir(); dir != "" {
+		newPath = dir
+	}
+	return target{
+		oldPath:    r.Old.Path,
+		newPath:    newPath,
+		newVersion: r.New.Version,
+	}
+}
+
+// Merge combines replacements of the same module with the same fork
+// and version, keeping track of all of the files they came from. The
+// highest required version is kept.
+func Merge(replacements []*Replacement) []*Replacement {
+	result := []*Replacement{}
+	byTarget := map[target]*Replacement{}
+	for _, r := range replacements {
+		existing, ok := byTarget[r.target()]
+		if !ok {
+			merged := *r
+			merged.Sources = append([]string{}, r.Sources...)
+			byTarget[r.target()] = &merged
+			result = append(result, &merged)
+			continue
+		}
+		existing.Sources = append(existing.Sources, r.Sources...)
+		if semver.Compare(r.RequiredVersion, existing.RequiredVersion) > 0 {
+			existing.RequiredVersion = r.RequiredVersion
+		}
+	}
+	return result
+}
+
+// Conflict describes a module that is replaced with different forks
+// or versions in different places
+type Conflict struct {
+	Path         string
+	Replacements []*Replacement
+}
+
+func (c *Conflict) String() string {
+	targets := []string{}
+	for _, r := range c.Replacements {
+		targets = append(targets, fmt.Sprintf("%s %s (%s)",
+			r.New.Path, r.New.Version, strings.Join(r.Sources, ", ")))
+	}
+	return fmt.Sprintf("%s is replaced by %s", c.Path, strings.Join(targets, " and "))
+}
+
+// Conflicts finds the modules replaced with more than one fork or
+// version among merged replacements. Several replacements for
+// different versions of a module in the same file are deliberate, so
+// they are not reported.
+func Conflicts(replacements []*Replacement) []*Conflict {
+	byPath := map[string]*Conflict{}
+	for _, r := range replacements {
+		c, ok := byPath[r.Old.Path]
+		if !ok {
+			c = &Conflict{Path: r.Old.Path}
+			byPath[r.Old.Path] = c
+		}
+		c.Replacements = append(c.Replacements, r)
+	}
+
+	result := []*Conflict{}
+	for _, c := range byPath {
+		if len(c.Replacements) < 2 {
+			continue
+		}
+		sources := map[string]bool{}
+		for _, r := range c.Replacements {
+			for _, source := range r.Sources {
+				sources[source] = true
+			}
+		}
+		if len(sources) > 1 {
+			result = append(result, c)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Path < result[j].Path
+	})
+	return result
+}
@@ -0,0 +1,124 @@
+package gomod
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/mod/module"
+)
+
+// VendorFilename is the name of the file describing the vendor
+// directory
+const VendorFilename = "modules.txt"
+
+// IsVendorFile reports whether filename looks like a vendor/modules.txt
+// file
+func IsVendorFile(filename string) bool {
+	return filepath.Base(filename) == VendorFilename
+}
+
+// ReadVendorFile returns the replacements recorded in the
+// "# old [version] => new [version]" lines of a vendor/modules.txt
+// file. Relative directories are relative to the module containing
+// the vendor directory.
+func ReadVendorFile(filename string) ([]*Replacement, error) {
+	body, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read vendor file")
+	}
+
+	moduleDir := filepath.Dir(filepath.Dir(filename))
+	result := []*Replacement{}
+	for i, line := range strings.Split(string(body), "\n") {
+		if !strings.HasPrefix(line, "# ") {
+			continue
+		}
+		fields := strings.Fields(line[2:])
+		arrow := -1
+		for j, f := range fields {
+			if f == "=>" {
+				arrow = j
+				break
+			}
+		}
+		if arrow < 0 {
+			continue
+		}
+		oldFields, newFields := fields[:arrow], fields[arrow+1:]
+		if len(oldFields) < 1 || len(oldFields) > 2 || len(newFields) < 1 || len(newFields) > 2 {
+			return nil, fmt.Errorf("%s:%d: could not parse replacement %q", filename, i+1, line)
+		}
+
+		r := &Replacement{
+			Old:          module.Version{Path: oldFields[0]},
+			New:          module.Version{Path: newFields[0]},
+			Sources:      []string{filename},
+			Dir:          moduleDir,
+			requiredFrom: "vendor/modules.txt",
+		}
+		// The version on the left is the one selected for the build,
+		// which is what the fork should be compared with.
+		if len(oldFields) == 2 {
+			r.RequiredVersion = oldFields[1]
+		}
+		if len(newFields) == 2 {
+			r.New.Version = newFields[1]
+		}
+		result = append(result, r)
+	}
+	return result, nil
+}
+
+// CheckVendor compares the replacements from a vendor/modules.txt file
+// with the replace directives in the go.mod file next to the vendor
+// directory and describes any differences
+func CheckVendor(filename string, vendored []*Replacement) ([]string, error) {
+	modFilename := filepath.Join(filepath.Dir(filepath.Dir(filename)), "go.mod")
+	if _, err := os.Stat(modFilename); os.IsNotExist(err) {
+		return []string{fmt.Sprintf("no %s to compare with %s", modFilename, filename)}, nil
+	}
+	declared, err := ReadModFile(modFilename)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[module.Version]*Replacement{}
+	for _, r := range declared {
+		byVersion[r.Old] = r
+	}
+
+	warnings := []string{}
+	seen := map[string]bool{}
+	for _, r := range vendored {
+		seen[r.Old.Path] = true
+		// The vendor file gives the version of the module that is
+		// replaced, which go.mod may replace alone or along with
+		// every other version.
+		d, ok := byVersion[module.Version{Path: r.Old.Path, Version: r.RequiredVersion}]
+		if !ok {
+			d, ok = byVersion[module.Version{Path: r.Old.Path}]
+		}
+		if !ok {
+			old := strings.TrimSpace(r.Old.Path + " " + r.RequiredVersion)
+			warnings = append(warnings, fmt.Sprintf("%s replaces %s but %s does not",
+				filename, old, modFilename))
+			continue
+		}
+		if d.target().newPath != r.target().newPath || d.New.Version != r.New.Version {
+			warnings = append(warnings, fmt.Sprintf("%s replaces %s with %s %s but %s uses %s %s",
+				filename, r.Old.Path, r.New.Path, r.New.Version,
+				modFilename, d.New.Path, d.New.Version))
+		}
+	}
+	for _, d := range declared {
+		if !seen[d.Old.Path] {
+			warnings = append(warnings, fmt.Sprintf("%s replaces %s but %s does not",
+				modFilename, d.Old.Path, filename))
+		}
+	}
+	return warnings, nil
+}
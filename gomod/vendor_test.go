@@ -0,0 +1,144 @@
+package gomod
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/mod/module"
+)
+
+func TestReadVendorFile(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		body    string
+		want    []Replacement
+		wantErr bool
+	}{
+		{"no replacements", "# github.com/u/x v1.0.0\n## explicit\ngithub.com/u/x\n", []Replacement{}, false},
+		{
+			"versions on both sides",
+			"# github.com/u/x v1.0.0 => github.com/f/x v1.0.1-fork\n## explicit\ngithub.com/u/x\n",
+			[]Replacement{{
+				Old:             module.Version{Path: "github.com/u/x"},
+				New:             module.Version{Path: "github.com/f/x", Version: "v1.0.1-fork"},
+				RequiredVersion: "v1.0.0",
+			}},
+			false,
+		},
+		{
+			"local directory",
+			"# github.com/u/x v1.0.0 => ../x\n# github.com/u/y => github.com/f/y v0.2.0\n",
+			[]Replacement{
+				{
+					Old:             module.Version{Path: "github.com/u/x"},
+					New:             module.Version{Path: "../x"},
+					RequiredVersion: "v1.0.0",
+				},
+				{
+					Old: module.Version{Path: "github.com/u/y"},
+					New: module.Version{Path: "github.com/f/y", Version: "v0.2.0"},
+				},
+			},
+			false,
+		},
+		{"too many fields", "# github.com/u/x v1.0.0 extra => ../x\n", nil, true},
+		{"missing target", "# github.com/u/x v1.0.0 =>\n", nil, true},
+	} {
+		dir := writeTree(t, map[string]string{"vendor/modules.txt": tc.body})
+		filename := filepath.Join(dir, "vendor", "modules.txt")
+		got, err := ReadVendorFile(filename)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected an error", tc.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			continue
+		}
+		want := []*Replacement{}
+		for i := range tc.want {
+			r := tc.want[i]
+			r.Sources = []string{filename}
+			r.Dir = dir
+			r.requiredFrom = "vendor/modules.txt"
+			want = append(want, &r)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: ReadVendorFile() = %+v, want %+v", tc.name, got, want)
+		}
+	}
+}
+
+func TestCheckVendor(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		mod      string
+		vendor   string
+		warnings []string
+	}{
+		{
+			"every version",
+			"module m\n\nreplace example.com/x => example.com/f/x v1.0.1\n",
+			"# example.com/x v1.0.0 => example.com/f/x v1.0.1\n",
+			nil,
+		},
+		{
+			"several versions",
+			"module m\n\nreplace example.com/x v1.0.0 => example.com/f/x v1.0.1\n\nreplace example.com/x v1.1.0 => example.com/f/x v1.1.1\n",
+			"# example.com/x v1.0.0 => example.com/f/x v1.0.1\n",
+			nil,
+		},
+		{
+			"version and wildcard",
+			"module m\n\nreplace example.com/x v1.0.0 => example.com/f/x v1.0.1\n\nreplace example.com/x => example.com/g/x v1.2.0\n",
+			"# example.com/x v1.1.0 => example.com/g/x v1.2.0\n",
+			nil,
+		},
+		{
+			"different fork",
+			"module m\n\nreplace example.com/x v1.0.0 => example.com/f/x v1.0.1\n\nreplace example.com/x v1.1.0 => example.com/f/x v1.1.1\n",
+			"# example.com/x v1.0.0 => example.com/g/x v1.0.1\n",
+			[]string{"replaces example.com/x with example.com/g/x v1.0.1 but"},
+		},
+		{
+			"version not replaced",
+			"module m\n\nreplace example.com/x v1.0.0 => example.com/f/x v1.0.1\n",
+			"# example.com/x v1.1.0 => example.com/f/x v1.1.1\n",
+			[]string{"replaces example.com/x v1.1.0 but"},
+		},
+		{
+			"missing from vendor",
+			"module m\n\nreplace example.com/x => example.com/f/x v1.0.1\n",
+			"# example.com/y v1.0.0\n",
+			[]string{"replaces example.com/x but"},
+		},
+	} {
+		dir := writeTree(t, map[string]string{
+			"go.mod":             tc.mod,
+			"vendor/modules.txt": tc.vendor,
+		})
+		filename := filepath.Join(dir, "vendor", "modules.txt")
+		vendored, err := ReadVendorFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		warnings, err := CheckVendor(filename, vendored)
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			continue
+		}
+		if len(warnings) != len(tc.warnings) {
+			t.Errorf("%s: got warnings %q, want %d", tc.name, warnings, len(tc.warnings))
+			continue
+		}
+		for i, want := range tc.warnings {
+			if !strings.Contains(warnings[i], want) {
+				t.Errorf("%s: got warning %q, want %q", tc.name, warnings[i], want)
+			}
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package gomod
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+)
+
+// ReadWorkFile returns the effective replacements for a workspace.
+// As in the go command, a replacement in the go.work file overrides
+// the replacements of the same module version in the used modules, or
+// of every version if it does not give one, and
+// the used modules may not replace the same module version
+// differently.
+func ReadWorkFile(filename string) ([]*Replacement, error) {
+	body, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read workspace file")
+	}
+	work, err := modfile.ParseWork(filename, body, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("could not parse %s", filename))
+	}
+
+	workDir := filepath.Dir(filename)
+	mods := make([]*modfile.File, 0, len(work.Use))
+	modFilenames := make([]string, 0, len(work.Use))
+	var required map[string]string
+	for _, use := range work.Use {
+		dir := use.Path
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(workDir, dir)
+		}
+		modFilename := filepath.Join(dir, "go.mod")
+		mod, err := parseModFile(modFilename)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("could not read module used by %s", filename))
+		}
+		mods = append(mods, mod)
+		modFilenames = append(modFilenames, modFilename)
+		required = requiredVersions(required, mod)
+	}
+
+	result := make([]*Replacement, 0, len(work.Replace))
+	replacedByWorkFile := map[module.Version]bool{}
+	for _, replace := range work.Replace {
+		replacedByWorkFile[replace.Old] = true
+		result = append(result, newReplacement(filename, replace, required))
+	}
+
+	seen := map[module.Version]*Replacement{}
+	for i, mod := range mods {
+		for _, replace := range mod.Replace {
+			// A replacement of every version in the go.work file
+			// overrides all of them, and one of a single version only
+			// overrides that version.
+			if replacedByWorkFile[module.Version{Path: replace.Old.Path}] || replacedByWorkFile[replace.Old] {
+				continue
+			}
+			r := newReplacement(modFilenames[i], replace, required)
+			if prev, ok := seen[replace.Old]; ok {
+				if !sameTarget(prev, r) {
+					return nil, fmt.Errorf(
+						"conflicting replacements for %s in %s and %s; add a replace to %s",
+						replace.Old, prev.Sources[0], r.Sources[0], filename)
+				}
+				continue
+			}
+			seen[replace.Old] = r
+			result = append(result, r)
+		}
+	}
+
+	return result, nil
+}
+
+// sameTarget reports whether two replacements point at the same
+// module, taking into account that local directories are relative to
+// the file they appear in
+func sameTarget(a, b *Replacement) bool {
+	if a.New.Version != "" || b.New.Version != "" {
+		return a.New == b.New
+	}
+	return a.LocalDir() == b.LocalDir()
+}
@@ -0,0 +1,121 @@
+package gomod
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadWorkFile(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		files map[string]string
+
+		// want maps each replaced module, with its version if the
+		// replacement has one, to the replacement and the file it
+		// comes from
+		want    map[string][2]string
+		wantErr string
+	}{
+		{
+			"modules only",
+			map[string]string{
+				"go.work":  "go 1.18\n\nuse (\n\t./a\n\t./b\n)\n",
+				"a/go.mod": "module a\n\nrequire github.com/u/x v1.0.0\n\nreplace github.com/u/x => github.com/f/x v1.0.1\n",
+				"b/go.mod": "module b\n\nreplace github.com/u/y => ../y\n",
+			},
+			map[string][2]string{
+				"github.com/u/x": {"github.com/f/x v1.0.1", "a/go.mod"},
+				"github.com/u/y": {"../y", "b/go.mod"},
+			},
+			"",
+		},
+		{
+			"work file overrides modules",
+			map[string]string{
+				"go.work":  "go 1.18\n\nuse ./a\n\nreplace github.com/u/x => github.com/w/x v1.0.2\n",
+				"a/go.mod": "module a\n\nreplace github.com/u/x v1.0.0 => github.com/f/x v1.0.1\n\nreplace github.com/u/x v1.1.0 => github.com/f/x v1.1.1\n",
+			},
+			map[string][2]string{
+				"github.com/u/x": {"github.com/w/x v1.0.2", "go.work"},
+			},
+			"",
+		},
+		{
+			"work file overrides one version",
+			map[string]string{
+				"go.work":  "go 1.18\n\nuse ./a\n\nreplace example.com/m v1.2.0 => example.com/w/m v1.2.1\n",
+				"a/go.mod": "module a\n\nreplace example.com/m => example.com/f/m v1.3.0\n\nreplace example.com/n v1.2.0 => example.com/f/n v1.2.1\n",
+			},
+			map[string][2]string{
+				"example.com/m v1.2.0": {"example.com/w/m v1.2.1", "go.work"},
+				"example.com/m":        {"example.com/f/m v1.3.0", "a/go.mod"},
+				"example.com/n v1.2.0": {"example.com/f/n v1.2.1", "a/go.mod"},
+			},
+			"",
+		},
+		{
+			"work file overrides the same version only",
+			map[string]string{
+				"go.work":  "go 1.18\n\nuse ./a\n\nreplace example.com/m v1.2.0 => example.com/w/m v1.2.1\n",
+				"a/go.mod": "module a\n\nreplace example.com/m v1.2.0 => example.com/f/m v1.2.2\n\nreplace example.com/m v1.1.0 => example.com/f/m v1.1.1\n",
+			},
+			map[string][2]string{
+				"example.com/m v1.2.0": {"example.com/w/m v1.2.1", "go.work"},
+				"example.com/m v1.1.0": {"example.com/f/m v1.1.1", "a/go.mod"},
+			},
+			"",
+		},
+		{
+			"same replacement in several modules",
+			map[string]string{
+				"go.work":  "go 1.18\n\nuse (\n\t./a\n\t./b\n)\n",
+				"a/go.mod": "module a\n\nreplace github.com/u/x => ../x\n",
+				"b/go.mod": "module b\n\nreplace github.com/u/x => ./../x\n",
+			},
+			map[string][2]string{
+				"github.com/u/x": {"../x", "a/go.mod"},
+			},
+			"",
+		},
+		{
+			"conflicting modules",
+			map[string]string{
+				"go.work":  "go 1.18\n\nuse (\n\t./a\n\t./b\n)\n",
+				"a/go.mod": "module a\n\nreplace github.com/u/x => github.com/f/x v1.0.1\n",
+				"b/go.mod": "module b\n\nreplace github.com/u/x => github.com/g/x v1.0.1\n",
+			},
+			nil,
+			"conflicting replacements for github.com/u/x",
+		},
+	} {
+		dir := writeTree(t, tc.files)
+		got, err := ReadWorkFile(filepath.Join(dir, "go.work"))
+		if tc.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("%s: expected error %q, got %v", tc.name, tc.wantErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			continue
+		}
+		if len(got) != len(tc.want) {
+			t.Errorf("%s: got %d replacements, want %d", tc.name, len(got), len(tc.want))
+		}
+		for _, r := range got {
+			target := strings.TrimSpace(r.New.Path + " " + r.New.Version)
+			source, err := filepath.Rel(dir, r.Sources[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			old := strings.TrimSpace(r.Old.Path + " " + r.Old.Version)
+			want, ok := tc.want[old]
+			if !ok || target != want[0] || filepath.ToSlash(source) != want[1] {
+				t.Errorf("%s: %s replaced by %s from %s, want %s from %s",
+					tc.name, old, target, source, want[0], want[1])
+			}
+		}
+	}
+}
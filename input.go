@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/dhellmann/go-fork-diff/config"
+	"github.com/dhellmann/go-fork-diff/gomod"
+	"github.com/dhellmann/go-fork-diff/vcs"
+	"github.com/pkg/errors"
+)
+
+// options holds the settings shared by all of the commands
+type options struct {
+	workDir      string
+	cfg          *config.Config
+	outputFormat string
+	jobs         int
+	verbose      bool
+	backend      vcs.Backend
+
+	// proxies are the module proxies to download zips from, when the
+	// trees are compared without cloning the repositories
+	proxies []vcs.Proxy
+}
+
+// input holds the replacements read from the input file that pass the
+// filters, and the repositories for them
+type input struct {
+	filename  string
+	replaces  []*gomod.Replacement
+	conflicts []*gomod.Conflict
+	repos     []*vcs.Repo
+}
+
+// readInput parses the input file named in args and filters the
+// replacements
+func readInput(opts *options, args []string) (*input, error) {
+	if len(args) != 1 {
+		return nil, errors.New("specify exactly one go.mod, go.work or modules.txt file, or a directory, to read")
+	}
+
+	in := &input{filename: args[0]}
+	allReplaces, err := gomod.Read(in.filename)
+	if err != nil {
+		return nil, err
+	}
+	if gomod.IsVendorFile(in.filename) {
+		warnings, err := gomod.CheckVendor(in.filename, allReplaces)
+		if err != nil {
+			return nil, err
+		}
+		for _, warning := range warnings {
+			log.Printf("WARNING: %s", warning)
+		}
+	}
+	allReplaces = gomod.Merge(allReplaces)
+
+	for _, replace := range allReplaces {
+		if opts.cfg.Include(replace.New.Path) {
+			in.replaces = append(in.replaces, replace)
+		}
+	}
+
+	in.conflicts = gomod.Conflicts(in.replaces)
+	for _, conflict := range in.conflicts {
+		log.Printf("WARNING: %s", conflict)
+	}
+
+	return in, nil
+}
+
+// resolve finds the upstream and fork repositories for each
+// replacement. It does not run git against any remote repository.
+func (in *input) resolve(opts *options) error {
+	repoAliases := opts.cfg.RepoAliases()
+
+	in.repos = make([]*vcs.Repo, len(in.replaces))
+	return runParallel(opts.jobs, len(in.replaces), func(i int) error {
+		replace := in.replaces[i]
+
+		// Use the version from the replace statement if there is
+		// one, otherwise look for the original version from the
+		// thing that was being replaced.
+		oldVersion, versionSource := replace.UpstreamVersion()
+
+		var repo *vcs.Repo
+		var err error
+		if opts.proxies != nil {
+			repo, err = vcs.NewProxy(
+				opts.workDir,
+				replace.Old.Path,
+				oldVersion,
+				replace.New.Path,
+				replace.New.Version,
+				replace.LocalDir(),
+				opts.proxies,
+				repoAliases,
+			)
+		} else if localDir := replace.LocalDir(); localDir != "" {
+			repo, err = vcs.NewLocal(
+				opts.workDir,
+				replace.Old.Path,
+				oldVersion,
+				localDir,
+				repoAliases,
+				opts.backend,
+			)
+		} else {
+			repo, err = vcs.New(
+				opts.workDir,
+				replace.Old.Path,
+				oldVersion,
+				replace.New.Path,
+				replace.New.Version,
+				repoAliases,
+			)
+		}
+		if err != nil {
+			return errors.Wrap(err, replace.Old.Path)
+		}
+		repo.SetOldVersion(oldVersion, versionSource)
+		repo.SetBackend(opts.backend)
+		if branch := opts.cfg.ForkBranch(replace.Old.Path); branch != "" {
+			repo.SetForkBranch(branch)
+		}
+		in.repos[i] = repo
+		return nil
+	})
+}
+
+// clone populates the cache and the local clone for each repository,
+// or downloads the module zips when using a proxy, applies the version
+// rules, and checks the versions against the commits. Repositories
+// that are unavailable offline are kept so they can be reported.
+func (in *input) clone(opts *options) error {
+	return runParallel(opts.jobs, len(in.repos), func(i int) error {
+		repo := in.repos[i]
+		if opts.proxies != nil {
+			// The upstream version must be known before downloading
+			// it, and there are no tag messages without a clone.
+			err := applyVersionRules(opts.cfg, repo, notCloned)
+			if err != nil {
+				return err
+			}
+		}
+		err := repo.Clone(opts.verbose)
+		if err != nil {
+			return errors.Wrap(err, repo.OldPath())
+		}
+		if opts.proxies == nil {
+			// Nothing was cloned for a repository that is unavailable
+			// offline, but rules that do not need tag messages apply.
+			tagMessage := repo.ForkTagMessage
+			if repo.Unavailable() != "" {
+				tagMessage = notCloned
+			}
+			err = applyVersionRules(opts.cfg, repo, tagMessage)
+			if err != nil {
+				return err
+			}
+		}
+		for _, problem := range repo.CheckVersions() {
+			log.Printf("WARNING: %s", problem)
+		}
+		return nil
+	})
+}
+
+// errNotCloned is returned in place of a tag message when the fork has
+// not been cloned
+var errNotCloned = errors.New("the fork has not been cloned")
+
+func notCloned() (string, error) {
+	return "", errNotCloned
+}
+
+// applyVersionRules sets the upstream version from the first version
+// rule that matches. A rule such as stripping a -k3sN suffix from the
+// new version takes precedence over the module files.
+func applyVersionRules(cfg *config.Config, repo *vcs.Repo, tagMessage config.TagMessageFunc) error {
+	version, rule, err := cfg.UpstreamVersion(
+		repo.OldPath(), repo.NewVersion(), tagMessage)
+	if err != nil {
+		if errors.Cause(err) == errNotCloned {
+			return nil
+		}
+		return errors.Wrap(err, repo.OldPath())
+	}
+	if rule != nil {
+		repo.SetOldVersion(version, fmt.Sprintf("version rule %q", rule.Name))
+	}
+	return nil
+}
@@ -3,24 +3,32 @@ package main
 import (
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"os"
-	"regexp"
 	"strings"
 
+	"github.com/dhellmann/go-fork-diff/config"
+	"github.com/dhellmann/go-fork-diff/report"
 	"github.com/dhellmann/go-fork-diff/vcs"
-	"golang.org/x/mod/modfile"
 )
 
 func init() {
 	flag.Usage = func() {
-		fmt.Fprintf(flag.CommandLine.Output(), "%s [options] go-mod-file\n\n", os.Args[0])
-		fmt.Fprintf(flag.CommandLine.Output(), "  go-mod-file\n")
-		fmt.Fprintf(flag.CommandLine.Output(), "    path to a go.mod file\n")
-		fmt.Fprintf(flag.CommandLine.Output(), "\n")
+		out := flag.CommandLine.Output()
+		fmt.Fprintf(out, "%s [options] [command] %s\n\n", os.Args[0], inputArgs)
+		fmt.Fprintf(out, "  %s\n", inputArgs)
+		fmt.Fprintf(out, "    path to a go.mod, go.work or vendor/modules.txt file, or a directory\n")
+		fmt.Fprintf(out, "    to search for go.mod files\n")
+		fmt.Fprintf(out, "\nCommands:\n")
+		for _, cmd := range commands {
+			fmt.Fprintf(out, "  %s %s\n", cmd.name, cmd.args)
+			for _, line := range strings.Split(cmd.help, "\n") {
+				fmt.Fprintf(out, "    %s\n", line)
+			}
+		}
+		fmt.Fprintf(out, "\nOptions:\n")
 		flag.PrintDefaults()
-		fmt.Fprintf(flag.CommandLine.Output(), "\n")
+		fmt.Fprintf(out, "\n")
 	}
 }
 
@@ -32,11 +40,29 @@ func handleError(err error) {
 	os.Exit(1)
 }
 
+func validFormat(format string) bool {
+	if format == "text" {
+		return true
+	}
+	for _, f := range report.Formats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
 func main() {
 	var (
 		replaceFilterPrefix string
 		workDir             string = "/tmp/go-fork-diff"
+		configFile          string
+		outputFormat        string = "text"
+		jobs                int    = 1
 		verbose             bool
+		backendName         string = "git"
+		useProxy            bool
+		offline             bool
 	)
 
 	flag.StringVar(&replaceFilterPrefix, "filter-prefix", "",
@@ -47,84 +73,101 @@ func main() {
 		"working directory")
 	flag.StringVar(&workDir, "w", workDir,
 		"working directory")
+	flag.StringVar(&configFile, "config", "",
+		fmt.Sprintf("configuration file (defaults to %s in the working directory)",
+			config.DefaultFilename))
+	flag.StringVar(&configFile, "c", "",
+		"configuration file")
+	formatHelp := fmt.Sprintf("output format (text, %s)",
+		strings.Join(report.Formats, ", "))
+	flag.StringVar(&outputFormat, "output", outputFormat, formatHelp)
+	flag.StringVar(&outputFormat, "o", outputFormat, formatHelp)
+	flag.IntVar(&jobs, "j", jobs,
+		"number of repositories to resolve and clone at the same time")
 	flag.BoolVar(&verbose, "v", false, "verbose output")
+	backendHelp := fmt.Sprintf("how to run the version control operations (%s)",
+		strings.Join(vcs.Backends, ", "))
+	flag.StringVar(&backendName, "backend", backendName, backendHelp)
+	flag.StringVar(&backendName, "b", backendName, backendHelp)
+	proxyHelp := "compare module zips downloaded from GOPROXY instead of cloning repositories (no commit log)"
+	flag.BoolVar(&useProxy, "proxy", false, proxyHelp)
+	flag.BoolVar(&useProxy, "p", false, proxyHelp)
+	flag.BoolVar(&offline, "offline", false,
+		"do not use the network, only the cache in the working directory and, with -proxy, the module cache")
 	flag.Parse()
 
-	if len(flag.Args()) != 1 {
-		fmt.Fprintf(os.Stderr, "ERROR: Specify exactly one go.mod file to read\n\n")
+	if jobs < 1 {
+		fmt.Fprintf(os.Stderr, "ERROR: -j must be at least 1\n\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if !validFormat(outputFormat) {
+		fmt.Fprintf(os.Stderr, "ERROR: Unknown output format %q\n\n", outputFormat)
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	backend, err := vcs.NewBackend(backendName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n\n", err)
 		flag.Usage()
 		os.Exit(1)
 	}
 
 	log.SetFlags(0)
 
-	modFilename := flag.Args()[0]
-	modBody, err := ioutil.ReadFile(modFilename)
+	if offline {
+		vcs.SetOffline()
+	}
+	err = vcs.LoadResolutions(workDir)
 	handleError(err)
 
-	mod, err := modfile.Parse(modFilename, modBody, nil)
+	cfg, err := config.Find(configFile, workDir)
 	handleError(err)
-
-	// TODO: Add a command line option for specifying these.
-	repoAliases := []vcs.Alias{
-		{
-			NewPrefix: "github.com/rancher/kubernetes/staging",
-			OldRepo:   "github.com/kubernetes/kubernetes",
-		},
+	if verbose && cfg.Filename() != "" {
+		log.Printf("using configuration from %s", cfg.Filename())
 	}
 
-	suffixMatcher := regexp.MustCompile(`-k3s\d$`)
-
-	repos := make([]*vcs.Repo, 0)
-	for _, replace := range mod.Replace {
-		if replaceFilterPrefix != "" &&
-			!strings.HasPrefix(replace.New.Path, replaceFilterPrefix) {
-			continue
-		}
-
-		oldVersion := replace.Old.Version
-
-		// If we have a -k3sN suffix in the new version, strip that
-		// and use the remaining value as the old version.
-		if suffixMatcher.MatchString(replace.New.Version) {
-			oldVersion = suffixMatcher.ReplaceAllLiteralString(replace.New.Version, "")
-		} else {
-			// If we don't have a good version specifier in the replace
-			// statement, look for the original version from the thing
-			// that was being replaced.
-			if oldVersion == "" {
-				for _, req := range mod.Require {
-					if req.Mod.Path == replace.Old.Path {
-						oldVersion = req.Mod.Version
-						break
-					}
-				}
-			}
-		}
+	// A prefix given on the command line replaces the ones from the
+	// configuration file.
+	if replaceFilterPrefix != "" {
+		cfg.FilterPrefixes = []string{replaceFilterPrefix}
+	}
 
-		repo, err := vcs.New(
-			workDir,
-			replace.Old.Path,
-			oldVersion,
-			replace.New.Path,
-			replace.New.Version,
-			repoAliases,
-		)
+	opts := &options{
+		workDir:      workDir,
+		cfg:          cfg,
+		outputFormat: outputFormat,
+		jobs:         jobs,
+		verbose:      verbose,
+		backend:      backend,
+	}
+	if useProxy {
+		opts.proxies, err = vcs.Proxies()
 		handleError(err)
-		repos = append(repos, repo)
 	}
 
-	for _, repo := range repos {
-		err = repo.Clone(verbose)
-		handleError(err)
+	// Without a command name, behave as we always have and report on
+	// the input file.
+	args := flag.Args()
+	cmd := findCommand(flag.Arg(0))
+	if cmd != nil {
+		args = args[1:]
+	} else {
+		cmd = findCommand("report")
 	}
 
-	for _, repo := range repos {
-		fmt.Printf("\n------------------------------------------------------------\n%s\n------------------------------------------------------------\n\n", repo.String())
-		err = repo.Log()
-		handleError(err)
-		fmt.Printf("\n\n")
-		err = repo.DiffStat()
-		handleError(err)
+	err = cmd.run(opts, args)
+
+	// Remember where the import paths were found and which parts of
+	// the cache were used, even after a failure, so later runs can
+	// work offline and maintain the cache.
+	if saveErr := vcs.SaveResolutions(workDir); saveErr != nil {
+		log.Printf("WARNING: %s", saveErr)
+	}
+	if saveErr := vcs.SaveCacheUsage(workDir); saveErr != nil {
+		log.Printf("WARNING: %s", saveErr)
 	}
+	handleError(err)
 }
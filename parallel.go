@@ -0,0 +1,39 @@
+package main
+
+import "sync"
+
+// runParallel calls fn for each index from 0 to n-1, running at most
+// jobs calls at the same time, and returns the first error
+func runParallel(jobs, n int, fn func(i int) error) error {
+	var (
+		wg       sync.WaitGroup
+		lock     sync.Mutex
+		firstErr error
+	)
+
+	indexes := make(chan int)
+	for w := 0; w < jobs && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				err := fn(i)
+				if err != nil {
+					lock.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					lock.Unlock()
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	return firstErr
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestRunParallel(t *testing.T) {
+	const jobs, n = 3, 20
+	var (
+		lock          sync.Mutex
+		running, most int
+		called        = make([]int, n)
+	)
+	err := runParallel(jobs, n, func(i int) error {
+		lock.Lock()
+		called[i]++
+		running++
+		if running > most {
+			most = running
+		}
+		lock.Unlock()
+
+		time.Sleep(time.Millisecond)
+
+		lock.Lock()
+		running--
+		lock.Unlock()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, count := range called {
+		if count != 1 {
+			t.Errorf("called %d %d times", i, count)
+		}
+	}
+	if most > jobs {
+		t.Errorf("ran %d at the same time, more than %d", most, jobs)
+	}
+}
+
+func TestRunParallelError(t *testing.T) {
+	failure := errors.New("failed")
+	var (
+		lock   sync.Mutex
+		called int
+	)
+	err := runParallel(1, 5, func(i int) error {
+		lock.Lock()
+		called++
+		lock.Unlock()
+		if i >= 2 {
+			return failure
+		}
+		return nil
+	})
+	if err != failure {
+		t.Errorf("got %v, want %v", err, failure)
+	}
+	// Every call is still made
+	if called != 5 {
+		t.Errorf("called %d times, want 5", called)
+	}
+}
+
+func TestRunParallelNothing(t *testing.T) {
+	err := runParallel(4, 0, func(i int) error {
+		t.Errorf("called %d", i)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
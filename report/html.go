@@ -0,0 +1,152 @@
+package report
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+)
+
+var htmlFuncs = template.FuncMap{
+	"anchor": func(i int) string {
+		return fmt.Sprintf("fork-%d", i+1)
+	},
+	"short":  shortHash,
+	"commit": commitURL,
+	"file":   fileURL,
+	"lines": func(f *Fork) string {
+		added, deleted := f.LineCounts()
+		return fmt.Sprintf("%d insertions(+), %d deletions(-)", added, deleted)
+	},
+}
+
+var htmlTemplate = template.Must(template.New("report").Funcs(htmlFuncs).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Fork differences for {{.ModFile}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 0.2em 0.5em; text-align: left; }
+td.num { text-align: right; }
+code, pre { font-family: monospace; }
+pre { background: #f6f8fa; padding: 0.5em; overflow-x: auto; }
+summary { cursor: pointer; }
+.added { color: #22863a; }
+.deleted { color: #b31d28; }
+</style>
+</head>
+<body>
+<h1>Fork differences for {{.ModFile}}</h1>
+
+<h2>Contents</h2>
+<table>
+<tr><th>Module</th><th>Upstream version</th><th>Fork version</th><th>Commits ahead</th><th>Still carried</th><th>Files changed</th></tr>
+{{- range $i, $fork := .Forks}}
+<tr>
+<td><a href="#{{anchor $i}}">{{$fork.OldPath}}</a></td>
+<td><code>{{$fork.OldVersion}}</code></td>
+<td><code>{{$fork.NewVersion}}</code></td>
+<td class="num">{{len $fork.Commits}}</td>
+<td class="num">{{$fork.Carry.Carried}}</td>
+<td class="num">{{len $fork.Files}}</td>
+</tr>
+{{- end}}
+</table>
+
+{{- with .Conflicts}}
+
+<h2>Conflicting replacements</h2>
+<ul>
+{{- range .}}
+<li>{{.Path}}
+<ul>
+{{- range .Targets}}
+<li>{{.NewPath}} @ <code>{{.NewVersion}}</code> in {{range $j, $f := .UsedBy}}{{if $j}}, {{end}}<code>{{$f}}</code>{{end}}</li>
+{{- end}}
+</ul>
+</li>
+{{- end}}
+</ul>
+{{- end}}
+
+{{- range $i, $fork := .Forks}}
+<h2 id="{{anchor $i}}">{{$fork.OldPath}}</h2>
+<table>
+<tr><th>Upstream</th><td>{{$fork.OldPath}} @ <code>{{$fork.OldVersion}}</code></td><td><a href="{{$fork.OldRepo}}">{{$fork.OldRepo}}</a></td></tr>
+<tr><th>Fork</th><td>{{$fork.NewPath}} @ <code>{{$fork.NewVersion}}</code></td><td><a href="{{$fork.NewRepo}}">{{$fork.NewRepo}}</a></td></tr>
+{{- if $fork.Aliased}}
+<tr><th>Aliased</th><td colspan="2">{{$fork.Aliased}}</td></tr>
+{{- end}}
+{{- if $fork.VersionSource}}
+<tr><th>Version from</th><td colspan="2">{{$fork.VersionSource}}</td></tr>
+{{- end}}
+{{- with $fork.UsedBy}}
+<tr><th>Used by</th><td colspan="2">{{range $j, $f := .}}{{if $j}}<br>{{end}}<code>{{$f}}</code>{{end}}</td></tr>
+{{- end}}
+{{- with $fork.OldSHA}}
+<tr><th>Upstream commit</th><td colspan="2">{{with commit $fork.OldRepo .}}<a href="{{.}}">{{end}}<code>{{.}}</code>{{if commit $fork.OldRepo .}}</a>{{end}}</td></tr>
+{{- end}}
+{{- with $fork.NewSHA}}
+<tr><th>Fork commit</th><td colspan="2">{{with commit $fork.NewRepo .}}<a href="{{.}}">{{end}}<code>{{.}}</code>{{if commit $fork.NewRepo .}}</a>{{end}}</td></tr>
+{{- end}}
+{{- with $fork.MergeBase}}
+<tr><th>Merge base</th><td colspan="2">{{with commit $fork.OldRepo .}}<a href="{{.}}">{{end}}<code>{{.}}</code>{{if commit $fork.OldRepo .}}</a>{{end}}</td></tr>
+{{- end}}
+{{- with $fork.Divergence}}
+<tr><th>Divergence</th><td colspan="2">{{.}}</td></tr>
+{{- end}}
+{{- with $fork.BaseRelease}}
+<tr><th>Based on</th><td colspan="2">{{if .Mismatch}}<strong>{{.}}</strong>{{else}}{{.}}{{end}}</td></tr>
+{{- end}}
+{{- with $fork.TreeDiff}}
+<tr><th>Compared files only</th><td colspan="2">{{.}}</td></tr>
+{{- end}}
+</table>
+
+{{- if $fork.Unavailable}}
+<p>Not compared, {{$fork.Unavailable}}.</p>
+{{- else if and (not $fork.MergeBase) (not $fork.TreeDiff)}}
+<p>No common ancestor, nothing to compare.</p>
+{{- else}}
+{{- if not $fork.TreeDiff}}
+
+<h3>{{len $fork.Commits}} commits</h3>
+<p>{{$fork.Carry}}</p>
+<table>
+<tr><th>Commit</th><th>Date</th><th>Author</th><th>Subject</th><th>Carry</th></tr>
+{{- range $fork.Commits}}
+<tr>
+<td>{{with commit $fork.NewRepo .Hash}}<a href="{{.}}">{{end}}<code>{{short .Hash}}</code>{{if commit $fork.NewRepo .Hash}}</a>{{end}}</td>
+<td>{{.Date.Format "2006-01-02 15:04"}}</td>
+<td>{{.Author}}</td>
+<td>{{.Subject}}</td>
+<td>{{.Carry}}</td>
+</tr>
+{{- end}}
+</table>
+{{- end}}
+
+<h3>{{len $fork.Files}} files changed, {{lines $fork}}</h3>
+{{- range $fork.Files}}
+<details>
+<summary><code>{{.Path}}</code>
+{{if .Binary}}(binary){{else}}<span class="added">+{{.Added}}</span> <span class="deleted">-{{.Deleted}}</span>{{end}}
+{{- with file $fork.OldRepo $fork.OldSource $fork.OldSHA .Path}} <a href="{{.}}">upstream</a>{{end}}
+{{- with file $fork.NewRepo $fork.NewSource $fork.NewSHA .Path}} <a href="{{.}}">fork</a>{{end}}
+</summary>
+<pre>{{.Patch}}</pre>
+</details>
+{{- end}}
+{{- end}}
+{{- end}}
+</body>
+</html>
+`))
+
+// WriteHTML writes the report as a self-contained HTML page with a
+// table of contents, links to the repository hosts, and expandable
+// diffs for each file
+func WriteHTML(w io.Writer, r *Report) error {
+	return htmlTemplate.Execute(w, r)
+}
@@ -0,0 +1,37 @@
+package report
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteHTML(t *testing.T) {
+	r := testReport()
+	r.Forks[0].NewRepo = "https://github.com/f/x.git"
+
+	var out strings.Builder
+	err := WriteHTML(&out, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := out.String()
+	for _, want := range []string{
+		"<td><a href=\"#fork-1\">example.com/u/x</a></td>\n",
+		"<h2 id=\"fork-2\">example.com/u/y</h2>\n",
+		"<li>example.com/g/z @ <code>v1.0.2</code> in <code>b/go.mod</code></li>\n",
+		"<tr><th>Used by</th><td colspan=\"2\"><code>a/go.mod</code><br><code>b/go.mod</code></td></tr>\n",
+		"<td><a href=\"https://github.com/f/x/commit/0123456789abcdef0123456789abcdef01234567\"><code>0123456789ab</code></a></td>\n",
+		"<td>Unclassified &lt;change&gt;</td>\n",
+		"<code>a.go</code>\n<span class=\"added\">+3</span> <span class=\"deleted\">-1</span> <a href=\"https://github.com/f/x/blob/fedcba9876543210fedcba9876543210fedcba98/a.go\">fork</a>\n",
+		"<code>logo.png</code>\n(binary)",
+		"&#43;&lt;script&gt;\n</pre>",
+		"<p>Not compared, unavailable offline.</p>\n",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("html does not contain %q:\n%s", want, text)
+		}
+	}
+	if strings.Contains(text, "<script>") {
+		t.Errorf("html contains an unescaped patch:\n%s", text)
+	}
+}
@@ -0,0 +1,84 @@
+package report
+
+import (
+	urlpkg "net/url"
+	"path"
+	"strings"
+
+	"github.com/dhellmann/go-fork-diff/discovery"
+)
+
+// browseBase returns the URL for browsing a repository in a web UI
+// and the host serving it
+func browseBase(repoURL string) (string, string) {
+	url, err := urlpkg.Parse(repoURL)
+	if err != nil || url.Host == "" {
+		return "", ""
+	}
+	url.Scheme = "https"
+	url.User = nil
+	url.Path = strings.TrimSuffix(strings.TrimSuffix(url.Path, "/"), ".git")
+	return url.String(), url.Hostname()
+}
+
+// commitURL returns a link to the commit in the web UI of the host,
+// or an empty string if we do not know how to build one
+func commitURL(repoURL, hash string) string {
+	if hash == "" {
+		return ""
+	}
+	base, host := browseBase(repoURL)
+	switch {
+	case base == "":
+		return ""
+	case host == "github.com":
+		return base + "/commit/" + hash
+	case host == "gitlab.com" || strings.HasPrefix(host, "gitlab."):
+		return base + "/-/commit/" + hash
+	case host == "bitbucket.org":
+		return base + "/commits/" + hash
+	case strings.HasSuffix(host, ".googlesource.com"):
+		return base + "/+/" + hash
+	}
+	return ""
+}
+
+// fileURL returns a link to the file at ref in the web UI of the
+// host. When the host is not recognized the go-source file template
+// is used instead, if there is one, although it usually points at the
+// default branch rather than at ref.
+func fileURL(repoURL string, source *discovery.Source, ref, filename string) string {
+	if ref == "" {
+		return ""
+	}
+	base, host := browseBase(repoURL)
+	switch {
+	case base == "":
+	case host == "github.com":
+		return base + "/blob/" + ref + "/" + filename
+	case host == "gitlab.com" || strings.HasPrefix(host, "gitlab."):
+		return base + "/-/blob/" + ref + "/" + filename
+	case host == "bitbucket.org":
+		return base + "/src/" + ref + "/" + filename
+	case strings.HasSuffix(host, ".googlesource.com"):
+		return base + "/+/" + ref + "/" + filename
+	}
+
+	if source == nil || source.File == "" || source.File == "_" {
+		return ""
+	}
+	dir, file := path.Split(filename)
+	dir = strings.TrimSuffix(dir, "/")
+	slashDir := ""
+	if dir != "" {
+		slashDir = "/" + dir
+	}
+	replacer := strings.NewReplacer(
+		"{dir}", dir,
+		"{/dir}", slashDir,
+		"{file}", file,
+		"{line}", "",
+		"{#line}", "",
+	)
+	return strings.TrimSuffix(replacer.Replace(source.File), "#L")
+}
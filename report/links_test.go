@@ -0,0 +1,49 @@
+package report
+
+import (
+	"testing"
+
+	"github.com/dhellmann/go-fork-diff/discovery"
+)
+
+func TestCommitURL(t *testing.T) {
+	for _, tc := range []struct {
+		repo, want string
+	}{
+		{"https://github.com/o/r.git", "https://github.com/o/r/commit/abc"},
+		{"http://user@gitlab.example.com/o/r/", "https://gitlab.example.com/o/r/-/commit/abc"},
+		{"https://bitbucket.org/o/r", "https://bitbucket.org/o/r/commits/abc"},
+		{"https://go.googlesource.com/mod", "https://go.googlesource.com/mod/+/abc"},
+		{"https://example.com/o/r", ""},
+		{"not a url", ""},
+	} {
+		if got := commitURL(tc.repo, "abc"); got != tc.want {
+			t.Errorf("commitURL(%q) = %q, want %q", tc.repo, got, tc.want)
+		}
+	}
+	if got := commitURL("https://github.com/o/r", ""); got != "" {
+		t.Errorf("commitURL without a hash = %q", got)
+	}
+}
+
+func TestFileURL(t *testing.T) {
+	source := &discovery.Source{File: "https://example.com/o/r/src/main{/dir}/{file}#L{line}"}
+	for _, tc := range []struct {
+		name   string
+		repo   string
+		source *discovery.Source
+		file   string
+		want   string
+	}{
+		{"github", "https://github.com/o/r", nil, "a/b.go", "https://github.com/o/r/blob/abc/a/b.go"},
+		{"gitlab", "https://gitlab.com/o/r", nil, "b.go", "https://gitlab.com/o/r/-/blob/abc/b.go"},
+		{"go-source", "https://example.com/o/r", source, "a/b.go", "https://example.com/o/r/src/main/a/b.go"},
+		{"go-source top", "https://example.com/o/r", source, "b.go", "https://example.com/o/r/src/main/b.go"},
+		{"unknown", "https://example.com/o/r", nil, "b.go", ""},
+		{"no file template", "https://example.com/o/r", &discovery.Source{File: "_"}, "b.go", ""},
+	} {
+		if got := fileURL(tc.repo, tc.source, "abc", tc.file); got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package report
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// WriteMarkdown writes the report in a form suitable for pasting into
+// a pull request description, with a summary table followed by a
+// section for each fork
+func WriteMarkdown(w io.Writer, r *Report) error {
+	out := bufio.NewWriter(w)
+
+	fmt.Fprintf(out, "| Module | Upstream version | Fork version | Commits ahead | Still carried | Files changed |\n")
+	fmt.Fprintf(out, "| --- | --- | --- | ---: | ---: | ---: |\n")
+	for _, fork := range r.Forks {
+		fmt.Fprintf(out, "| [%s](#%s) | %s | %s | %d | %d | %d |\n",
+			mdEscape(fork.OldPath), mdAnchor(fork.OldPath),
+			mdCode(fork.OldVersion), mdCode(fork.NewVersion),
+			len(fork.Commits), fork.Carry.Carried(), len(fork.Files),
+		)
+	}
+
+	if len(r.Conflicts) > 0 {
+		fmt.Fprintf(out, "\n## Conflicting replacements\n\n")
+		for _, conflict := range r.Conflicts {
+			fmt.Fprintf(out, "- %s\n", mdEscape(conflict.Path))
+			for _, target := range conflict.Targets {
+				fmt.Fprintf(out, "  - %s @ %s in %s\n",
+					mdEscape(target.NewPath), mdCode(target.NewVersion),
+					mdCodeList(target.UsedBy))
+			}
+		}
+	}
+
+	for _, fork := range r.Forks {
+		fmt.Fprintf(out, "\n## %s\n\n", mdEscape(fork.OldPath))
+		fmt.Fprintf(out, "- upstream: %s @ %s (%s)\n",
+			mdEscape(fork.OldPath), mdCode(fork.OldVersion), fork.OldRepo)
+		fmt.Fprintf(out, "- fork: %s @ %s (%s)\n",
+			mdEscape(fork.NewPath), mdCode(fork.NewVersion), fork.NewRepo)
+		if fork.Aliased != "" {
+			fmt.Fprintf(out, "- aliased: %s\n", fork.Aliased)
+		}
+		if fork.VersionSource != "" {
+			fmt.Fprintf(out, "- version from: %s\n", mdEscape(fork.VersionSource))
+		}
+		if len(fork.UsedBy) > 0 {
+			fmt.Fprintf(out, "- used by: %s\n", mdCodeList(fork.UsedBy))
+		}
+
+		switch {
+		case fork.Unavailable != "":
+			fmt.Fprintf(out, "\nNot compared, %s.\n", mdEscape(fork.Unavailable))
+			continue
+		case fork.TreeDiff != "":
+			fmt.Fprintf(out, "- compared files only: %s\n", mdEscape(fork.TreeDiff))
+		case fork.MergeBase == "":
+			fmt.Fprintf(out, "\nNo common ancestor, nothing to compare.\n")
+			continue
+		default:
+			writeMarkdownHistory(out, fork)
+		}
+
+		added, deleted := fork.LineCounts()
+		fmt.Fprintf(out, "\n<details>\n<summary>%d files changed, %d insertions(+), %d deletions(-)</summary>\n\n",
+			len(fork.Files), added, deleted)
+		if len(fork.Files) > 0 {
+			fmt.Fprintf(out, "| File | + | - |\n")
+			fmt.Fprintf(out, "| --- | ---: | ---: |\n")
+			for _, file := range fork.Files {
+				if file.Binary {
+					fmt.Fprintf(out, "| %s | bin | bin |\n", mdCode(file.Path))
+					continue
+				}
+				fmt.Fprintf(out, "| %s | %d | %d |\n", mdCode(file.Path), file.Added, file.Deleted)
+			}
+		}
+		fmt.Fprintf(out, "\n</details>\n")
+	}
+
+	return out.Flush()
+}
+
+// writeMarkdownHistory writes the merge base and the commits of a fork
+func writeMarkdownHistory(out io.Writer, fork *Fork) {
+	fmt.Fprintf(out, "- merge base: %s\n", mdCode(fork.MergeBase))
+	if fork.BaseRelease != nil {
+		fmt.Fprintf(out, "- based on: %s\n", mdEscape(fork.BaseRelease.String()))
+	}
+	fmt.Fprintf(out, "- carry: %s\n", fork.Carry)
+	if fork.Divergence != nil {
+		fmt.Fprintf(out, "- divergence: %s\n", fork.Divergence)
+	}
+
+	fmt.Fprintf(out, "\n<details>\n<summary>%d commits</summary>\n\n", len(fork.Commits))
+	for _, commit := range fork.Commits {
+		fmt.Fprintf(out, "- %s %s %s (%s)",
+			mdCode(shortHash(commit.Hash)),
+			commit.Date.Format("2006-01-02"),
+			mdEscape(commit.Subject),
+			mdEscape(commit.Author),
+		)
+		if commit.Carry != "" {
+			fmt.Fprintf(out, " _%s_", mdEscape(commit.Carry))
+		}
+		fmt.Fprintf(out, "\n")
+	}
+	fmt.Fprintf(out, "\n</details>\n")
+}
+
+// mdEscape protects text that might otherwise be interpreted as
+// markdown or break a table
+func mdEscape(s string) string {
+	replacer := strings.NewReplacer(
+		`\`, `\\`,
+		"|", `\|`,
+		"*", `\*`,
+		"_", `\_`,
+		"`", "\\`",
+		"<", "&lt;",
+		">", "&gt;",
+		"[", `\[`,
+		"]", `\]`,
+	)
+	return replacer.Replace(s)
+}
+
+func mdCode(s string) string {
+	if s == "" {
+		return ""
+	}
+	return fmt.Sprintf("`%s`", strings.ReplaceAll(s, "|", `\|`))
+}
+
+func mdCodeList(items []string) string {
+	quoted := make([]string, 0, len(items))
+	for _, item := range items {
+		quoted = append(quoted, mdCode(item))
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// mdAnchor builds the anchor GitHub generates for a heading
+func mdAnchor(heading string) string {
+	var b strings.Builder
+	for _, c := range strings.ToLower(heading) {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-', c == '_':
+			b.WriteRune(c)
+		case c == ' ':
+			b.WriteRune('-')
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,65 @@
+package report
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteMarkdown(t *testing.T) {
+	var out strings.Builder
+	err := WriteMarkdown(&out, testReport())
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := out.String()
+	for _, want := range []string{
+		"| [example.com/u/x](#examplecomux) | `v1.0.0` | `v1.0.0-fork` | 2 | 1 | 2 |\n",
+		"| [example.com/u/y](#examplecomuy) | `v0.1.0` | `v0.1.1` | 0 | 0 | 0 |\n",
+		"## Conflicting replacements\n",
+		"  - example.com/g/z @ `v1.0.2` in `b/go.mod`\n",
+		"- used by: `a/go.mod`, `b/go.mod`\n",
+		"- based on: v1.0.0\n",
+		"- `0123456789ab` 2020-01-02 Fix \\*everything\\* in some\\_func (A &lt;a@example.com&gt;) _fork-only_\n",
+		"- `fedcba987654` 2020-01-02 Unclassified &lt;change&gt; (B &lt;b@example.com&gt;)\n",
+		"| `a.go` | 3 | 1 |\n",
+		"| `logo.png` | bin | bin |\n",
+		"Not compared, unavailable offline.\n",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("markdown does not contain %q:\n%s", want, text)
+		}
+	}
+	if strings.Contains(text, "__") {
+		t.Errorf("markdown contains an empty emphasis:\n%s", text)
+	}
+}
+
+func TestMdEscape(t *testing.T) {
+	for _, tc := range []struct {
+		in, want string
+	}{
+		{"plain text", "plain text"},
+		{"a|b", `a\|b`},
+		{"*bold* _it_ `code`", "\\*bold\\* \\_it\\_ \\`code\\`"},
+		{"<b>[link]</b>", `&lt;b&gt;\[link\]&lt;/b&gt;`},
+		{`back\slash`, `back\\slash`},
+	} {
+		if got := mdEscape(tc.in); got != tc.want {
+			t.Errorf("mdEscape(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestMdAnchor(t *testing.T) {
+	for _, tc := range []struct {
+		in, want string
+	}{
+		{"example.com/u/x", "examplecomux"},
+		{"github.com/Foo/bar-baz_v2", "githubcomfoobar-baz_v2"},
+		{"Conflicting replacements", "conflicting-replacements"},
+	} {
+		if got := mdAnchor(tc.in); got != tc.want {
+			t.Errorf("mdAnchor(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
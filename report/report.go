@@ -0,0 +1,213 @@
+package report
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/dhellmann/go-fork-diff/discovery"
+	"github.com/dhellmann/go-fork-diff/gomod"
+	"github.com/dhellmann/go-fork-diff/vcs"
+	"github.com/pkg/errors"
+)
+
+// Formats lists the output formats the report can be written in
+var Formats = []string{"json", "markdown", "html"}
+
+// Write renders the report in the named format
+func Write(w io.Writer, format string, r *Report) error {
+	switch format {
+	case "json":
+		return WriteJSON(w, r)
+	case "markdown":
+		return WriteMarkdown(w, r)
+	case "html":
+		return WriteHTML(w, r)
+	}
+	return fmt.Errorf("unknown output format %q", format)
+}
+
+// Report holds the results for all of the forks in one run
+type Report struct {
+	// ModFile is the input the replacements were read from
+	ModFile string `json:"mod_file"`
+
+	Forks []*Fork `json:"forks"`
+
+	// Conflicts lists modules replaced by different forks or
+	// versions in different places
+	Conflicts []*Conflict `json:"conflicts,omitempty"`
+}
+
+// Conflict describes a module with more than one replacement
+type Conflict struct {
+	Path    string    `json:"path"`
+	Targets []*Target `json:"targets"`
+}
+
+// Target is one of the replacements for a conflicting module
+type Target struct {
+	NewPath    string   `json:"new_path"`
+	NewVersion string   `json:"new_version"`
+	UsedBy     []string `json:"used_by"`
+}
+
+// AddConflicts records the conflicting replacements found in the
+// input
+func (r *Report) AddConflicts(conflicts []*gomod.Conflict) {
+	for _, c := range conflicts {
+		conflict := &Conflict{Path: c.Path}
+		for _, replacement := range c.Replacements {
+			conflict.Targets = append(conflict.Targets, &Target{
+				NewPath:    replacement.New.Path,
+				NewVersion: replacement.New.Version,
+				UsedBy:     replacement.Sources,
+			})
+		}
+		r.Conflicts = append(r.Conflicts, conflict)
+	}
+}
+
+// NeedsPatches reports whether the format includes the full diff of
+// each file
+func NeedsPatches(format string) bool {
+	return format == "html"
+}
+
+// Fork holds the results for one replacement
+type Fork struct {
+	OldPath       string            `json:"old_path"`
+	OldVersion    string            `json:"old_version"`
+	OldRepo       string            `json:"old_repo"`
+	OldSource     *discovery.Source `json:"old_source,omitempty"`
+	VersionSource string            `json:"version_source,omitempty"`
+	Aliased       string            `json:"aliased,omitempty"`
+
+	NewPath    string            `json:"new_path"`
+	NewVersion string            `json:"new_version"`
+	NewRepo    string            `json:"new_repo"`
+	NewSource  *discovery.Source `json:"new_source,omitempty"`
+
+	// UsedBy lists the files with the replace directive
+	UsedBy []string `json:"used_by,omitempty"`
+
+	// OldSHA and NewSHA are the resolved commits for the versions
+	OldSHA string `json:"old_sha,omitempty"`
+	NewSHA string `json:"new_sha,omitempty"`
+
+	// MergeBase is empty when the versions share no history, in
+	// which case there are no commits or files
+	MergeBase string `json:"merge_base,omitempty"`
+
+	// BaseRelease is the upstream release nearest to the merge base
+	BaseRelease *vcs.BaseRelease `json:"base_release,omitempty"`
+
+	Commits []vcs.Commit   `json:"commits"`
+	Files   []vcs.FileStat `json:"files"`
+
+	// Carry counts the commits that are still only in the fork
+	Carry vcs.CarrySummary `json:"carry"`
+
+	// Divergence measures how stale the fork is
+	Divergence *vcs.Divergence `json:"divergence,omitempty"`
+
+	// TreeDiff explains why only the files of the two versions were
+	// compared, without their history
+	TreeDiff string `json:"tree_diff,omitempty"`
+
+	// Unavailable explains why nothing was compared, when the
+	// repositories or module zips could not be found offline
+	Unavailable string `json:"unavailable,omitempty"`
+}
+
+// Build collects the results for the repositories, which must already
+// be cloned. Collecting the patches for every file is optional
+// because they can be very large.
+func Build(modFile string, repos []*vcs.Repo, includePatches bool) (*Report, error) {
+	result := &Report{
+		ModFile: modFile,
+		Forks:   make([]*Fork, 0, len(repos)),
+	}
+	for _, repo := range repos {
+		fork, err := newFork(repo, includePatches)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("could not build report for %s", repo.OldPath()))
+		}
+		result.Forks = append(result.Forks, fork)
+	}
+	return result, nil
+}
+
+func newFork(repo *vcs.Repo, includePatches bool) (*Fork, error) {
+	fork := &Fork{
+		OldPath:       repo.OldPath(),
+		OldVersion:    repo.OldVersion(),
+		OldRepo:       repo.OldRepo(),
+		OldSource:     repo.OldSource(),
+		VersionSource: repo.OldVersionSource(),
+		Aliased:       repo.Aliased(),
+		NewPath:       repo.NewPath(),
+		NewVersion:    repo.NewVersion(),
+		NewRepo:       repo.NewRepo(),
+		NewSource:     repo.NewSource(),
+	}
+
+	fork.Unavailable = repo.Unavailable()
+	if fork.Unavailable != "" {
+		return fork, nil
+	}
+
+	var err error
+	fork.OldSHA, fork.NewSHA = repo.ResolveRefs()
+	fork.MergeBase = repo.MergeBase()
+	fork.TreeDiff = repo.TreeDiff()
+	fork.BaseRelease, err = repo.NearestRelease()
+	if err != nil {
+		return nil, err
+	}
+
+	fork.Commits, err = repo.Commits()
+	if err != nil {
+		return nil, err
+	}
+	err = repo.ClassifyCommits(fork.Commits)
+	if err != nil {
+		return nil, err
+	}
+	fork.Carry = vcs.Summarize(fork.Commits)
+	fork.Divergence, err = repo.Divergence(fork.Commits)
+	if err != nil {
+		return nil, err
+	}
+	fork.Files, err = repo.DiffStats()
+	if err != nil {
+		return nil, err
+	}
+
+	if includePatches {
+		patches, err := repo.Patches()
+		if err != nil {
+			return nil, err
+		}
+		for i := range fork.Files {
+			fork.Files[i].Patch = patches[fork.Files[i].Path]
+		}
+	}
+	return fork, nil
+}
+
+// LineCounts returns the total number of lines added and deleted
+func (f *Fork) LineCounts() (int, int) {
+	added, deleted := 0, 0
+	for _, file := range f.Files {
+		added += file.Added
+		deleted += file.Deleted
+	}
+	return added, deleted
+}
+
+func shortHash(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
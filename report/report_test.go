@@ -0,0 +1,71 @@
+package report
+
+import (
+	"time"
+
+	"github.com/dhellmann/go-fork-diff/vcs"
+)
+
+// testReport returns a report with a compared fork, one that could not
+// be compared, and a conflict
+func testReport() *Report {
+	date := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	commits := []vcs.Commit{
+		{
+			Hash:    "0123456789abcdef0123456789abcdef01234567",
+			Date:    date,
+			Author:  "A <a@example.com>",
+			Subject: "Fix *everything* in some_func",
+			Carry:   vcs.CarryForkOnly,
+		},
+		{
+			Hash:    "fedcba9876543210fedcba9876543210fedcba98",
+			Date:    date,
+			Author:  "B <b@example.com>",
+			Subject: "Unclassified <change>",
+		},
+	}
+	return &Report{
+		ModFile: "go.mod",
+		Forks: []*Fork{
+			{
+				OldPath:    "example.com/u/x",
+				OldVersion: "v1.0.0",
+				OldRepo:    "https://example.com/u/x",
+				NewPath:    "example.com/f/x",
+				NewVersion: "v1.0.0-fork",
+				NewRepo:    "https://example.com/f/x",
+				UsedBy:     []string{"a/go.mod", "b/go.mod"},
+				OldSHA:     "1111111111111111111111111111111111111111",
+				NewSHA:     "fedcba9876543210fedcba9876543210fedcba98",
+				MergeBase:  "1111111111111111111111111111111111111111",
+				BaseRelease: &vcs.BaseRelease{
+					Tag:     "v1.0.0",
+					Version: "v1.0.0",
+				},
+				Commits: commits,
+				Files: []vcs.FileStat{
+					{Path: "a.go", Added: 3, Deleted: 1, Patch: "diff --git a/a.go b/a.go\n+<script>\n"},
+					{Path: "logo.png", Binary: true},
+				},
+				Carry: vcs.Summarize(commits),
+			},
+			{
+				OldPath:     "example.com/u/y",
+				OldVersion:  "v0.1.0",
+				NewPath:     "example.com/f/y",
+				NewVersion:  "v0.1.1",
+				Unavailable: "unavailable offline",
+			},
+		},
+		Conflicts: []*Conflict{
+			{
+				Path: "example.com/u/z",
+				Targets: []*Target{
+					{NewPath: "example.com/f/z", NewVersion: "v1.0.1", UsedBy: []string{"a/go.mod"}},
+					{NewPath: "example.com/g/z", NewVersion: "v1.0.2", UsedBy: []string{"b/go.mod"}},
+				},
+			},
+		},
+	}
+}
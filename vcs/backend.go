@@ -0,0 +1,359 @@
+package vcs
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Backend runs the version control operations needed to compare a
+// fork with its upstream. Directories are local repositories with a
+// working tree, and revisions use the git revision syntax.
+type Backend interface {
+	// Clone copies the repository at url to the new directory dir
+	Clone(verbose bool, prefix, url, dir string) error
+
+	// RemoteURL returns the URL of the remote, or an empty string if
+	// the repository has no such remote
+	RemoteURL(dir, remote string) (string, error)
+
+	// AddRemote adds a remote to the repository
+	AddRemote(verbose bool, prefix, dir, remote, url string) error
+
+	// SetRemoteURL changes the URL of an existing remote
+	SetRemoteURL(verbose bool, prefix, dir, remote, url string) error
+
+	// Fetch updates the remote tracking branches and the tags from
+	// the remote
+	Fetch(verbose bool, prefix, dir, remote string) error
+
+	// DefaultBranch returns the remote tracking branch, such as
+	// "origin/main", for the branch the remote's HEAD points to
+	DefaultBranch(verbose bool, prefix, dir, remote string) (string, error)
+
+	// TopLevel returns the root of the working tree containing dir
+	TopLevel(dir string) (string, error)
+
+	// RevParse returns the hash of the commit a revision refers to
+	RevParse(dir, rev string) (string, error)
+
+	// HasFile reports whether there is a file at path in the tree of
+	// the revision
+	HasFile(dir, rev, path string) (bool, error)
+
+	// ReadFile returns the contents of the file at path in the tree of
+	// the revision
+	ReadFile(dir, rev, path string) ([]byte, error)
+
+	// MergeBase returns the best common ancestor of two revisions
+	MergeBase(dir, a, b string) (string, error)
+
+	// Log returns the commits reachable from to but not from from,
+	// newest first. When path is not empty only commits changing
+	// something under it are included.
+	Log(dir, from, to, path string) ([]Commit, error)
+
+	// Diff compares the trees of two revisions, limited by a git
+	// pathspec of directories to include and, starting with ":!",
+	// to exclude. The patch for each file is only filled in when
+	// patches is true.
+	Diff(dir, from, to string, pathspec []string, patches bool) ([]FileStat, error)
+}
+
+// Backends lists the names accepted by NewBackend
+var Backends = []string{"git", "go-git"}
+
+// NewBackend returns the backend with the name
+func NewBackend(name string) (Backend, error) {
+	switch name {
+	case "git":
+		return ExecBackend{}, nil
+	case "go-git":
+		return GoGitBackend{}, nil
+	}
+	return nil, fmt.Errorf("unknown backend %q", name)
+}
+
+var (
+	gitOnce  sync.Once
+	gitFound bool
+)
+
+// gitAvailable reports whether the git command can be run. The
+// features beyond the Backend interface, such as finding commits that
+// are already upstream, need it and are skipped without it.
+func gitAvailable() bool {
+	gitOnce.Do(func() {
+		_, err := exec.LookPath("git")
+		gitFound = err == nil
+	})
+	return gitFound
+}
+
+// errNoGit is returned by the features that need the git command when
+// it is not installed
+var errNoGit = errors.New("the git command is not available")
+
+const (
+	fieldSep  = "\x1f"
+	recordSep = "\x1e"
+)
+
+// ExecBackend runs the git command
+type ExecBackend struct{}
+
+// Clone implements Backend
+func (ExecBackend) Clone(verbose bool, prefix, url, dir string) error {
+	return runGit(verbose, prefix, filepath.Dir(dir), "clone", url, filepath.Base(dir))
+}
+
+// RemoteURL implements Backend
+func (ExecBackend) RemoteURL(dir, remote string) (string, error) {
+	out, err := gitOutput(dir, "config", "--get", fmt.Sprintf("remote.%s.url", remote))
+	if err != nil {
+		// git config exits with 1 when the remote is not configured,
+		// and with other codes when it cannot read the configuration
+		if exitErr, ok := errors.Cause(err).(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+			return "", nil
+		}
+		return "", errors.Wrap(err, fmt.Sprintf("could not read remote %s", remote))
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// AddRemote implements Backend
+func (ExecBackend) AddRemote(verbose bool, prefix, dir, remote, url string) error {
+	return runGit(verbose, prefix, dir, "remote", "add", remote, url)
+}
+
+// SetRemoteURL implements Backend
+func (ExecBackend) SetRemoteURL(verbose bool, prefix, dir, remote, url string) error {
+	return runGit(verbose, prefix, dir, "remote", "set-url", remote, url)
+}
+
+// Fetch implements Backend
+func (ExecBackend) Fetch(verbose bool, prefix, dir, remote string) error {
+	return runGit(verbose, prefix, dir, "fetch", remote, "--tags")
+}
+
+// DefaultBranch implements Backend
+func (ExecBackend) DefaultBranch(verbose bool, prefix, dir, remote string) (string, error) {
+	err := runGit(verbose, prefix, dir, "remote", "set-head", remote, "--auto")
+	if err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("could not find HEAD of %s", remote))
+	}
+	out, err := gitOutput(dir, "symbolic-ref", "--short",
+		fmt.Sprintf("refs/remotes/%s/HEAD", remote))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// RevParse implements Backend
+func (ExecBackend) RevParse(dir, rev string) (string, error) {
+	out, err := gitOutput(dir, "rev-parse", "--verify", "--quiet",
+		fmt.Sprintf("%s^{commit}", rev))
+	if err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("could not find %s", rev))
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// TopLevel implements Backend
+func (ExecBackend) TopLevel(dir string) (string, error) {
+	out, err := gitOutput(dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// HasFile implements Backend
+func (ExecBackend) HasFile(dir, rev, path string) (bool, error) {
+	// ls-tree lists nothing for a missing path, but fails for a
+	// missing revision
+	out, err := gitOutput(dir, "ls-tree", "--full-tree", rev, "--", path)
+	if err != nil {
+		return false, errors.Wrap(err, fmt.Sprintf("could not read tree of %s", rev))
+	}
+	for _, line := range strings.Split(out, "\n") {
+		if strings.Contains(line, " blob ") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ReadFile implements Backend
+func (ExecBackend) ReadFile(dir, rev, path string) ([]byte, error) {
+	out, err := gitOutput(dir, "show", fmt.Sprintf("%s:%s", rev, path))
+	if err != nil {
+		return nil, err
+	}
+	return []byte(out), nil
+}
+
+// MergeBase implements Backend
+func (ExecBackend) MergeBase(dir, a, b string) (string, error) {
+	out, err := gitOutput(dir, "merge-base", a, b)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// Log implements Backend
+func (ExecBackend) Log(dir, from, to, path string) ([]Commit, error) {
+	revRange := fmt.Sprintf("%s..%s", from, to)
+	args := []string{
+		"log",
+		fmt.Sprintf("--pretty=format:%%H%[1]s%%cI%[1]s%%an <%%ae>%[1]s%%s%[2]s",
+			fieldSep, recordSep),
+		revRange,
+	}
+	if path != "" {
+		args = append(args, "--", path)
+	}
+
+	out, err := gitOutput(dir, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("could not read log of %s", revRange))
+	}
+
+	commits := []Commit{}
+	for _, record := range strings.Split(out, recordSep) {
+		record = strings.TrimSpace(record)
+		if record == "" {
+			continue
+		}
+		fields := strings.SplitN(record, fieldSep, 4)
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("could not parse log entry %q", record)
+		}
+		date, err := time.Parse(time.RFC3339, fields[1])
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("could not parse date of %s", fields[0]))
+		}
+		commits = append(commits, Commit{
+			Hash:    fields[0],
+			Date:    date,
+			Author:  fields[2],
+			Subject: fields[3],
+		})
+	}
+	return commits, nil
+}
+
+// Diff implements Backend
+func (ExecBackend) Diff(dir, from, to string, pathspec []string, patches bool) ([]FileStat, error) {
+	revRange := fmt.Sprintf("%s..%s", from, to)
+
+	// The paths must match the ones in the headers of the patches,
+	// and -z keeps them from being quoted at all.
+	args := []string{"-c", "core.quotePath=false",
+		"diff", "--numstat", "-z", "--no-renames", revRange, "--"}
+	args = append(args, pathspec...)
+
+	out, err := gitOutput(dir, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("could not read diff of %s", revRange))
+	}
+
+	stats := []FileStat{}
+	for _, line := range strings.Split(out, "\x00") {
+		if line == "" {
+			continue
+		}
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("could not parse diff stat %q", line)
+		}
+		stat := FileStat{Path: fields[2]}
+		if fields[0] == "-" && fields[1] == "-" {
+			stat.Binary = true
+		} else {
+			stat.Added, err = strconv.Atoi(fields[0])
+			if err != nil {
+				return nil, errors.Wrap(err, fmt.Sprintf("could not parse diff stat %q", line))
+			}
+			stat.Deleted, err = strconv.Atoi(fields[1])
+			if err != nil {
+				return nil, errors.Wrap(err, fmt.Sprintf("could not parse diff stat %q", line))
+			}
+		}
+		stats = append(stats, stat)
+	}
+
+	if !patches {
+		return stats, nil
+	}
+
+	args = []string{"-c", "core.quotePath=false",
+		"diff", "--patch", "--no-renames", "--no-color", revRange, "--"}
+	args = append(args, pathspec...)
+
+	out, err = gitOutput(dir, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("could not read diff of %s", revRange))
+	}
+
+	byPath := splitPatches(out)
+	for i := range stats {
+		stats[i].Patch = byPath[stats[i].Path]
+	}
+	return stats, nil
+}
+
+// splitPatches splits the output of git diff up by the path of the
+// file
+func splitPatches(out string) map[string]string {
+	const header = "diff --git "
+	patches := map[string]string{}
+	var (
+		path    string
+		current strings.Builder
+	)
+	for _, line := range strings.SplitAfter(out, "\n") {
+		if strings.HasPrefix(line, header) {
+			if path != "" {
+				patches[path] = current.String()
+			}
+			current.Reset()
+			path = patchPath(strings.TrimSuffix(strings.TrimPrefix(line, header), "\n"))
+		}
+		current.WriteString(line)
+	}
+	if path != "" {
+		patches[path] = current.String()
+	}
+	return patches
+}
+
+// patchPath returns the path from the names in the header of a patch.
+// Without rename detection the header has the same path twice, as
+// "a/<path> b/<path>", and git quotes both names like Go strings when
+// the path has special characters.
+func patchPath(names string) string {
+	if strings.HasPrefix(names, `"`) {
+		for i := 1; i < len(names); i++ {
+			if names[i] == '\\' {
+				i++
+				continue
+			}
+			if names[i] == '"' {
+				if name, err := strconv.Unquote(names[:i+1]); err == nil {
+					return strings.TrimPrefix(name, "a/")
+				}
+				break
+			}
+		}
+	}
+	return strings.TrimPrefix(names[:(len(names)-1)/2], "a/")
+}
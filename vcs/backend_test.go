@@ -0,0 +1,121 @@
+package vcs
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// fakeBackend holds repositories in memory. Only the operations the
+// tests use are implemented, the rest panic.
+type fakeBackend struct {
+	Backend
+
+	// refs maps each revision to the commit it refers to
+	refs map[string]string
+
+	// files maps each commit to the contents of its files by path
+	files map[string]map[string]string
+}
+
+func (b fakeBackend) RevParse(dir, rev string) (string, error) {
+	if hash, ok := b.refs[rev]; ok {
+		return hash, nil
+	}
+	if _, ok := b.files[rev]; ok {
+		return rev, nil
+	}
+	return "", fmt.Errorf("could not find %s", rev)
+}
+
+func (b fakeBackend) HasFile(dir, rev, path string) (bool, error) {
+	hash, err := b.RevParse(dir, rev)
+	if err != nil {
+		return false, err
+	}
+	_, ok := b.files[hash][path]
+	return ok, nil
+}
+
+func (b fakeBackend) ReadFile(dir, rev, path string) ([]byte, error) {
+	hash, err := b.RevParse(dir, rev)
+	if err != nil {
+		return nil, err
+	}
+	body, ok := b.files[hash][path]
+	if !ok {
+		return nil, fmt.Errorf("%s does not exist in %s", path, rev)
+	}
+	return []byte(body), nil
+}
+
+func TestSplitPatches(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		out  string
+		want map[string]string
+	}{
+		{"empty", "", map[string]string{}},
+		{
+			"one file",
+			"diff --git a/a.go b/a.go\n--- a/a.go\n+++ b/a.go\n@@ -1 +1 @@\n-x\n+y\n",
+			map[string]string{
+				"a.go": "diff --git a/a.go b/a.go\n--- a/a.go\n+++ b/a.go\n@@ -1 +1 @@\n-x\n+y\n",
+			},
+		},
+		{
+			"several files",
+			"diff --git a/a.go b/a.go\n+a\ndiff --git a/dir/b.go b/dir/b.go\n+b\n",
+			map[string]string{
+				"a.go":     "diff --git a/a.go b/a.go\n+a\n",
+				"dir/b.go": "diff --git a/dir/b.go b/dir/b.go\n+b\n",
+			},
+		},
+		{
+			"path with spaces",
+			"diff --git a/x b/y.go b/x b/y.go\n+z\n",
+			map[string]string{
+				"x b/y.go": "diff --git a/x b/y.go b/x b/y.go\n+z\n",
+			},
+		},
+		{
+			"path that is not quoted",
+			"diff --git a/dir/é.go b/dir/é.go\n+é\n",
+			map[string]string{
+				"dir/é.go": "diff --git a/dir/é.go b/dir/é.go\n+é\n",
+			},
+		},
+		{
+			"quoted path",
+			"diff --git \"a/t\\tab\" \"b/t\\tab\"\n+t\ndiff --git \"a/\\303\\251.go\" \"b/\\303\\251.go\"\n+e\n",
+			map[string]string{
+				"t\tab": "diff --git \"a/t\\tab\" \"b/t\\tab\"\n+t\n",
+				"é.go":  "diff --git \"a/\\303\\251.go\" \"b/\\303\\251.go\"\n+e\n",
+			},
+		},
+	} {
+		got := splitPatches(tc.out)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: splitPatches() = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestExecBackendRemoteURL(t *testing.T) {
+	tr := newTestRepos(t)
+	backend := ExecBackend{}
+
+	url, err := backend.RemoteURL(tr.fork, "origin")
+	if err != nil || url != tr.upstream {
+		t.Errorf("RemoteURL(origin) = %q, %v, want %q", url, err, tr.upstream)
+	}
+	url, err = backend.RemoteURL(tr.fork, "missing")
+	if err != nil || url != "" {
+		t.Errorf("RemoteURL(missing) = %q, %v, want no remote", url, err)
+	}
+	_, err = backend.RemoteURL(filepath.Join(tr.workDir, "no-such-dir"), "origin")
+	if err == nil {
+		t.Errorf("RemoteURL in a missing directory did not fail")
+	}
+}
@@ -0,0 +1,208 @@
+package vcs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// BaseRelease describes the upstream release nearest to the point
+// where the fork branched
+type BaseRelease struct {
+	// Tag is the upstream tag, including any module directory prefix
+	Tag string `json:"tag"`
+
+	// Version is the module version the tag represents
+	Version string `json:"version"`
+
+	// Commit is the newest upstream commit in the fork history
+	Commit string `json:"commit"`
+
+	// Distance is the number of upstream commits after the tag in
+	// the fork history
+	Distance int `json:"distance"`
+
+	// Mismatch is set when the old version is a release that is not
+	// the one the fork is based on
+	Mismatch bool `json:"mismatch,omitempty"`
+}
+
+func (b *BaseRelease) String() string {
+	result := b.Tag
+	if b.Distance > 0 {
+		result = fmt.Sprintf("%s + %d upstream commits", b.Tag, b.Distance)
+	}
+	if b.Mismatch {
+		result += " (does not match the upstream version)"
+	}
+	return result
+}
+
+// upstreamBase returns the newest commits in the history of the new
+// version that are also in the upstream repository, on any of its
+// branches or tags. There is more than one when upstream has been
+// merged into the fork.
+func (r *Repo) upstreamBase() ([]string, error) {
+	_, newRef := r.gitRefs()
+
+	out, err := gitOutput(r.cachePath(r.oldRepo), "for-each-ref",
+		"--format=%(objectname) %(*objectname)", "refs/tags", "refs/heads", "refs/remotes/origin")
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("could not list refs of %s", r.oldRepo))
+	}
+	commits := []string{}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		// use the commit for annotated tags
+		commits = append(commits, fields[len(fields)-1])
+	}
+
+	// Refs may be in the cache before they are fetched into the local
+	// clone, which cannot exclude commits it does not have.
+	commits, err = r.presentObjects(commits)
+	if err != nil {
+		return nil, err
+	}
+	tips := []string{}
+	for _, commit := range commits {
+		tips = append(tips, "^"+commit)
+	}
+
+	// The boundary of the fork-only commits is where the fork joins
+	// the upstream history.
+	out, err = gitFilter(nil, strings.Join(tips, "\n")+"\n", r.localPath,
+		"rev-list", "--boundary", newRef, "--stdin")
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("could not find upstream base of %s", newRef))
+	}
+	bases := []string{}
+	forkOnly := false
+	for _, line := range strings.Split(out, "\n") {
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "-") {
+			bases = append(bases, line[1:])
+			continue
+		}
+		forkOnly = true
+	}
+	if !forkOnly {
+		// The new version is itself an upstream commit.
+		commit := r.resolveRef(newRef)
+		if commit != "" {
+			bases = append(bases, commit)
+		}
+	}
+	return bases, nil
+}
+
+// presentObjects returns the objects from the list that are in the
+// local clone
+func (r *Repo) presentObjects(hashes []string) ([]string, error) {
+	if len(hashes) == 0 {
+		return hashes, nil
+	}
+	out, err := gitFilter(nil, strings.Join(hashes, "\n")+"\n", r.localPath,
+		"cat-file", "--batch-check")
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("could not check objects in %s", r.localPath))
+	}
+	present := []string{}
+	for _, line := range strings.Split(out, "\n") {
+		// Missing objects are reported as "<hash> missing".
+		fields := strings.Fields(line)
+		if len(fields) == 3 {
+			present = append(present, fields[0])
+		}
+	}
+	return present, nil
+}
+
+// NearestRelease finds the upstream release tag closest to the point
+// where the fork joins the upstream history. It returns nil if the
+// fork shares no history with upstream, there is no release before
+// that point, or the git history cannot be inspected.
+func (r *Repo) NearestRelease() (*BaseRelease, error) {
+	if !r.HasGitHistory() {
+		return nil, nil
+	}
+	bases, err := r.upstreamBase()
+	if err != nil {
+		return nil, err
+	}
+
+	patterns := []string{"v[0-9]*"}
+	prefix := r.oldTagPrefix()
+	if prefix != "" {
+		patterns = append([]string{prefix + "v[0-9]*"}, patterns...)
+	}
+
+	// Look in the cache of the upstream repository so tags fetched
+	// from the fork are not considered.
+	for _, pattern := range patterns {
+		var nearest *BaseRelease
+		for _, commit := range bases {
+			out, err := gitOutput(r.cachePath(r.oldRepo), "describe", "--tags", "--long",
+				"--match", pattern, commit)
+			if err != nil {
+				continue
+			}
+			base := parseDescribe(strings.TrimSpace(out))
+			if base == nil {
+				continue
+			}
+			base.Commit = commit
+			base.Version = strings.TrimPrefix(base.Tag, prefix)
+			if nearest == nil || semver.Compare(base.Version, nearest.Version) > 0 ||
+				(base.Version == nearest.Version && base.Distance < nearest.Distance) {
+				nearest = base
+			}
+		}
+		if nearest != nil {
+			nearest.Mismatch = r.versionMismatch(nearest)
+			return nearest, nil
+		}
+	}
+	return nil, nil
+}
+
+// parseDescribe parses the long output of git describe, which looks
+// like "<tag>-<distance>-g<hash>"
+func parseDescribe(out string) *BaseRelease {
+	hashSep := strings.LastIndex(out, "-g")
+	if hashSep < 0 {
+		return nil
+	}
+	out = out[:hashSep]
+	distanceSep := strings.LastIndex(out, "-")
+	if distanceSep < 0 {
+		return nil
+	}
+	distance, err := strconv.Atoi(out[distanceSep+1:])
+	if err != nil {
+		return nil
+	}
+	return &BaseRelease{
+		Tag:      out[:distanceSep],
+		Distance: distance,
+	}
+}
+
+// versionMismatch reports whether the old version names a release
+// other than the one the fork is based on. Pseudo-versions name a
+// commit rather than a release, so they never disagree.
+func (r *Repo) versionMismatch(base *BaseRelease) bool {
+	version := strings.TrimSuffix(r.oldVersion, "+incompatible")
+	if !semver.IsValid(version) || module.IsPseudoVersion(version) {
+		return false
+	}
+	return base.Distance != 0 || semver.Compare(version, base.Version) != 0
+}
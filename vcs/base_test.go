@@ -0,0 +1,46 @@
+package vcs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseDescribe(t *testing.T) {
+	for _, tc := range []struct {
+		out  string
+		want *BaseRelease
+	}{
+		{"v1.2.3-0-g0123456", &BaseRelease{Tag: "v1.2.3"}},
+		{"v1.2.3-4-g0123456", &BaseRelease{Tag: "v1.2.3", Distance: 4}},
+		{"sub/dir/v1.2.3-rc.1-12-g0123456789ab", &BaseRelease{Tag: "sub/dir/v1.2.3-rc.1", Distance: 12}},
+		{"v1.2.3-g-1-g0123456", &BaseRelease{Tag: "v1.2.3-g", Distance: 1}},
+		{"v1.2.3", nil},
+		{"v1.2.3-g0123456", nil},
+		{"v1.2.3-x-g0123456", nil},
+		{"", nil},
+	} {
+		got := parseDescribe(tc.out)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parseDescribe(%q) = %+v, want %+v", tc.out, got, tc.want)
+		}
+	}
+}
+
+func TestNearestReleaseWithRefsOnlyInCache(t *testing.T) {
+	tr := newTestRepos(t)
+	tr.commit(t, tr.fork, "fork.go", "package x\n", "fork change")
+	tr.git(t, tr.fork, "tag", "v1.0.0-fork")
+	r := tr.repo(t, "v1.0.0", "v1.0.0-fork")
+
+	// A release made upstream after the local clone was updated
+	tr.commit(t, tr.upstream, "new.go", "package x\n", "upstream change")
+	tr.git(t, tr.upstream, "tag", "v1.1.0")
+
+	base, err := r.NearestRelease()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if base == nil || base.Tag != "v1.0.0" || base.Distance != 0 || base.Mismatch {
+		t.Errorf("NearestRelease() = %+v, want v1.0.0", base)
+	}
+}
@@ -0,0 +1,164 @@
+package vcs
+
+import (
+	"bufio"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// bzrTool runs Bazaar
+type bzrTool struct{}
+
+// bzrTimestamp is the layout of the dates in the long log format
+const bzrTimestamp = "Mon 2006-01-02 15:04:05 -0700"
+
+// Clone implements tool. The copies have no working tree because the
+// files are only ever exported.
+func (bzrTool) Clone(verbose bool, prefix, url, dir string) error {
+	return runTool(verbose, prefix, filepath.Dir(dir), "bzr", "branch", "--no-tree", url, dir)
+}
+
+// Pull implements tool. Bazaar cannot fetch the revisions of a branch
+// that has diverged without replacing the tip of the local one, so
+// the upstream revisions must already be in the history of the fork,
+// as they are when the fork was branched from upstream.
+func (bzrTool) Pull(verbose bool, prefix, dir, other string) error {
+	return nil
+}
+
+// revisionID returns the revision id for a revision specifier
+func (bzrTool) revisionID(dir, spec string) (string, error) {
+	out, err := toolOutput(dir, "bzr", "revision-info", "--directory", dir, "--revision", spec)
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(out)
+	if len(fields) != 2 {
+		return "", fmt.Errorf("could not parse revision info %q", out)
+	}
+	return fields[1], nil
+}
+
+// Revision implements tool. The commits in pseudo-versions of Bazaar
+// modules are revision numbers padded with zeros.
+func (t bzrTool) Revision(dir string, candidates []string) (string, error) {
+	if len(candidates) == 0 {
+		return t.revisionID(dir, "-1")
+	}
+	for _, candidate := range candidates {
+		specs := []string{"tag:" + candidate, "revid:" + candidate}
+		if strings.Trim(candidate, "0123456789") == "" {
+			specs = []string{strings.TrimLeft(candidate, "0")}
+		}
+		for _, spec := range specs {
+			if revid, err := t.revisionID(dir, spec); err == nil {
+				return revid, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no revision %s", candidates[0])
+}
+
+// MergeBase implements tool. Since the fork is expected to hold the
+// upstream revision, that revision is the common ancestor.
+func (t bzrTool) MergeBase(dir, a, b string) (string, error) {
+	return t.revisionID(dir, "revid:"+a)
+}
+
+// Log implements tool
+func (bzrTool) Log(dir, from, to, path string) ([]Commit, error) {
+	args := []string{"log", "--long", "--show-ids", "--levels", "0",
+		"--revision", fmt.Sprintf("revid:%s..revid:%s", from, to)}
+	if path != "" {
+		args = append(args, path)
+	}
+
+	out, err := toolOutput(dir, "bzr", args...)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("could not read log of %s..%s", from, to))
+	}
+
+	commits, err := parseBzrLog(out)
+	if err != nil {
+		return nil, err
+	}
+
+	// The range includes its start.
+	result := []Commit{}
+	for _, c := range commits {
+		if c.Hash != from {
+			result = append(result, c)
+		}
+	}
+	return result, nil
+}
+
+// parseBzrLog reads the long log format, in which merged revisions are
+// indented below the revision merging them
+func parseBzrLog(out string) ([]Commit, error) {
+	commits := []Commit{}
+	var (
+		current   *Commit
+		committer string
+		inMessage bool
+	)
+	finish := func() {
+		if current == nil {
+			return
+		}
+		if current.Author == "" {
+			current.Author = committer
+		}
+		commits = append(commits, *current)
+		current = nil
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "------------") {
+			finish()
+			current = &Commit{}
+			committer = ""
+			inMessage = false
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		if inMessage {
+			if current.Subject == "" {
+				current.Subject = line
+			}
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "revision-id:"):
+			current.Hash = strings.TrimSpace(strings.TrimPrefix(line, "revision-id:"))
+		case strings.HasPrefix(line, "committer:"):
+			committer = strings.TrimSpace(strings.TrimPrefix(line, "committer:"))
+		case strings.HasPrefix(line, "author:"):
+			current.Author = strings.TrimSpace(strings.TrimPrefix(line, "author:"))
+		case strings.HasPrefix(line, "timestamp:"):
+			date, err := time.Parse(bzrTimestamp, strings.TrimSpace(strings.TrimPrefix(line, "timestamp:")))
+			if err != nil {
+				return nil, errors.Wrap(err, fmt.Sprintf("could not parse date of %s", current.Hash))
+			}
+			current.Date = date
+		case line == "message:":
+			inMessage = true
+		}
+	}
+	finish()
+	return commits, scanner.Err()
+}
+
+// Export implements tool
+func (bzrTool) Export(dir, rev, dest string) error {
+	return runTool(false, "", dir, "bzr", "export", "--revision", "revid:"+rev, dest, dir)
+}
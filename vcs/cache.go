@@ -0,0 +1,443 @@
+package vcs
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// cacheUsage is what is remembered about one repository or module zip
+// in the cache
+type cacheUsage struct {
+	URL       string    `json:"url,omitempty"`
+	LastUsed  time.Time `json:"last_used"`
+	LastFetch time.Time `json:"last_fetch"`
+}
+
+var (
+	usageLock sync.Mutex
+
+	// usageUpdates holds the changes to the usage of the cache made
+	// during this run, by absolute path, until SaveCacheUsage is
+	// called. A nil value means the entry was removed.
+	usageUpdates = map[string]*cacheUsage{}
+)
+
+// touchCache records that the cache entry at path was used, if used
+// is set, and fetched from url, if fetched is set
+func touchCache(path, url string, used, fetched bool) {
+	now := time.Now().UTC()
+	usageLock.Lock()
+	defer usageLock.Unlock()
+	usage := usageUpdates[path]
+	if usage == nil {
+		usage = &cacheUsage{}
+		usageUpdates[path] = usage
+	}
+	if url != "" {
+		usage.URL = url
+	}
+	if used {
+		usage.LastUsed = now
+	}
+	if fetched {
+		usage.LastFetch = now
+	}
+}
+
+// cachedURL returns the URL the cache entry at path was fetched from,
+// as recorded by this run or an earlier one, or an empty string if it
+// is not known
+func cachedURL(workDir, path string) string {
+	usageLock.Lock()
+	update := usageUpdates[path]
+	usageLock.Unlock()
+	if update != nil && update.URL != "" {
+		return update.URL
+	}
+
+	usage, err := readCacheUsage(workDir)
+	if err != nil {
+		return ""
+	}
+	rel, err := filepath.Rel(filepath.Join(workDir, "_cache"), path)
+	if err != nil {
+		return ""
+	}
+	if saved := usage[filepath.ToSlash(rel)]; saved != nil {
+		return saved.URL
+	}
+	return ""
+}
+
+// usageFile is where the usage of the cache is kept between runs
+func usageFile(workDir string) string {
+	return filepath.Join(workDir, "_cache", "usage.json")
+}
+
+// readCacheUsage returns the usage saved by earlier runs, by path
+// within the cache
+func readCacheUsage(workDir string) (map[string]*cacheUsage, error) {
+	usage := map[string]*cacheUsage{}
+	filename := usageFile(workDir)
+	body, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return usage, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("could not read %s", filename))
+	}
+	err = json.Unmarshal(body, &usage)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("could not parse %s", filename))
+	}
+	return usage, nil
+}
+
+// SaveCacheUsage adds the use of the cache during this run to what
+// was recorded by earlier runs. Runs at the same time may lose each
+// other's updates, which only makes the times less accurate.
+func SaveCacheUsage(workDir string) error {
+	usageLock.Lock()
+	defer usageLock.Unlock()
+	if len(usageUpdates) == 0 {
+		return nil
+	}
+
+	usage, err := readCacheUsage(workDir)
+	if err != nil {
+		return err
+	}
+	root := filepath.Join(workDir, "_cache")
+	for path, update := range usageUpdates {
+		rel, err := filepath.Rel(root, path)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		if update == nil {
+			delete(usage, rel)
+			continue
+		}
+		saved := usage[rel]
+		if saved == nil {
+			usage[rel] = update
+			continue
+		}
+		if update.URL != "" {
+			saved.URL = update.URL
+		}
+		if update.LastUsed.After(saved.LastUsed) {
+			saved.LastUsed = update.LastUsed
+		}
+		if update.LastFetch.After(saved.LastFetch) {
+			saved.LastFetch = update.LastFetch
+		}
+	}
+
+	body, err := json.MarshalIndent(usage, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = writeFileAtomic(usageFile(workDir), append(body, '\n'))
+	if err != nil {
+		return err
+	}
+	usageUpdates = map[string]*cacheUsage{}
+	return nil
+}
+
+// writeFileAtomic replaces the file in one step, so a run that is
+// interrupted, or running at the same time, never sees half of it
+func writeFileAtomic(filename string, body []byte) error {
+	err := os.MkdirAll(filepath.Dir(filename), 0755)
+	if err != nil {
+		return errors.Wrap(err, "failed to create cache directory")
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".tmp-")
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("could not write %s", filename))
+	}
+	_, err = tmp.Write(body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filename)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return errors.Wrap(err, fmt.Sprintf("could not write %s", filename))
+	}
+	return nil
+}
+
+// CachePaths returns the locations within the cache, as used for the
+// Path of a CacheEntry, of the repositories and module zips the
+// versions are compared with, whether or not they have been fetched
+// yet
+func (r *Repo) CachePaths() []string {
+	root := filepath.Join(r.workDir, "_cache")
+	paths := []string{}
+	if r.proxies == nil && r.oldRepo != "" {
+		paths = append(paths, r.cachePath(r.oldRepo))
+		if r.localDir == "" && r.newRepo != "" {
+			paths = append(paths, r.cachePath(r.newRepo))
+		}
+	}
+	if path, err := r.zipCachePath(r.oldZipModule(), r.oldVersion); err == nil && r.oldVersion != "" {
+		paths = append(paths, path)
+	}
+	if r.localDir == "" {
+		if path, err := r.zipCachePath(r.newPath, r.newVersion); err == nil && r.newVersion != "" {
+			paths = append(paths, path)
+		}
+	}
+	for i, path := range paths {
+		rel, err := filepath.Rel(root, path)
+		if err == nil {
+			paths[i] = filepath.ToSlash(rel)
+		}
+	}
+	return paths
+}
+
+// CacheEntry describes one repository or module zip in the cache
+type CacheEntry struct {
+	// Path is the location of the entry within the cache
+	Path string
+
+	// Kind is the version control system of a repository, or "zip"
+	Kind string
+
+	// URL is where the entry was fetched from, if known
+	URL string
+
+	// Size is the number of bytes used on disk
+	Size int64
+
+	// LastUsed and LastFetch are the last times the entry was used
+	// in a comparison and updated from URL. Entries from before the
+	// usage was recorded have the time they were last modified.
+	LastUsed  time.Time
+	LastFetch time.Time
+
+	// dir is the absolute location of the entry
+	dir string
+}
+
+// cacheKind returns the kind of cache entry at path, or an empty
+// string if path is a directory holding other entries
+func cacheKind(path string, info os.FileInfo) string {
+	if !info.IsDir() {
+		if strings.HasSuffix(path, ".zip") {
+			return "zip"
+		}
+		return ""
+	}
+	for _, marker := range []struct{ name, kind string }{
+		{".git", "git"},
+		{".hg", "hg"},
+		{".bzr", "bzr"},
+		{".svn", "svn"},
+		{fossilFile, "fossil"},
+	} {
+		if _, err := os.Stat(filepath.Join(path, marker.name)); err == nil {
+			return marker.kind
+		}
+	}
+	return ""
+}
+
+// ListCache returns the repositories and module zips in the cache of
+// the working directory, sorted by path
+func ListCache(workDir string) ([]*CacheEntry, error) {
+	usage, err := readCacheUsage(workDir)
+	if err != nil {
+		return nil, err
+	}
+
+	root := filepath.Join(workDir, "_cache")
+	entries := []*CacheEntry{}
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) && path == root {
+			return filepath.SkipDir
+		}
+		if err != nil {
+			return err
+		}
+		kind := cacheKind(path, info)
+		if kind == "" {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		entry := &CacheEntry{
+			Path:      filepath.ToSlash(rel),
+			Kind:      kind,
+			LastUsed:  info.ModTime(),
+			LastFetch: info.ModTime(),
+			dir:       path,
+		}
+		if saved := usage[entry.Path]; saved != nil {
+			entry.URL = saved.URL
+			if !saved.LastUsed.IsZero() {
+				entry.LastUsed = saved.LastUsed
+			}
+			if !saved.LastFetch.IsZero() {
+				entry.LastFetch = saved.LastFetch
+			}
+		}
+		entry.Size, err = diskUsage(path)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read cache")
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+	return entries, nil
+}
+
+// diskUsage returns the total size of the files under path
+func diskUsage(path string) (int64, error) {
+	var size int64
+	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// Verify checks that a git repository is complete, or that a module
+// zip can be read. Other repositories are not checked.
+func (e *CacheEntry) Verify() error {
+	switch e.Kind {
+	case "git":
+		if !gitAvailable() {
+			return errNoGit
+		}
+		_, err := gitOutput(e.dir, "fsck", "--connectivity-only", "--no-progress")
+		return err
+	case "zip":
+		return verifyZip(e.dir)
+	}
+	return nil
+}
+
+// verifyZip reads every file in a zip, which checks their checksums
+func verifyZip(filename string) error {
+	archive, err := zip.OpenReader(filename)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+	for _, f := range archive.File {
+		in, err := f.Open()
+		if err != nil {
+			return errors.Wrap(err, f.Name)
+		}
+		_, err = io.Copy(ioutil.Discard, in)
+		in.Close()
+		if err != nil {
+			return errors.Wrap(err, f.Name)
+		}
+	}
+	return nil
+}
+
+// Refresh fetches the latest changes into a git repository, removing
+// the branches that were deleted from its remote. The local branches
+// are updated as well, because they are what the local clones fetch
+// from the cache. Module zips never change, and other repositories
+// are left alone.
+func (e *CacheEntry) Refresh(verbose bool) error {
+	if e.Kind != "git" {
+		return nil
+	}
+	if offline {
+		return errors.Wrap(errOffline, fmt.Sprintf("could not refresh %s", e.Path))
+	}
+	if !gitAvailable() {
+		return errNoGit
+	}
+	log.Printf("%s: fetching", e.Path)
+	err := runGit(verbose, e.Path, e.dir, "fetch", "--prune", "--update-head-ok", "origin",
+		"+refs/heads/*:refs/heads/*",
+		"+refs/heads/*:refs/remotes/origin/*",
+		"+refs/tags/*:refs/tags/*")
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("could not refresh %s", e.Path))
+	}
+	touchCache(e.dir, "", false, true)
+	e.LastFetch = time.Now().UTC()
+	return nil
+}
+
+// GC packs a git repository to save space. Other entries are left
+// alone.
+func (e *CacheEntry) GC(verbose bool) error {
+	if e.Kind != "git" {
+		return nil
+	}
+	if !gitAvailable() {
+		return errNoGit
+	}
+	err := runGit(verbose, e.Path, e.dir, "gc", "--quiet")
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("could not collect garbage in %s", e.Path))
+	}
+	return nil
+}
+
+// Remove deletes the entry from the cache, along with any directories
+// left empty
+func (e *CacheEntry) Remove() error {
+	unlock := lockPath(e.dir)
+	defer unlock()
+
+	err := os.RemoveAll(e.dir)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("could not remove %s", e.Path))
+	}
+	usageLock.Lock()
+	usageUpdates[e.dir] = nil
+	usageLock.Unlock()
+
+	root := strings.TrimSuffix(e.dir, filepath.FromSlash(e.Path))
+	for dir := filepath.Dir(e.dir); len(dir) > len(root); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package vcs
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeCache creates a repository of each kind and a module zip in
+// the cache of a new working directory
+func writeCache(t *testing.T) string {
+	t.Helper()
+	workDir, err := ioutil.TempDir("", "vcs-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(workDir) })
+
+	root := filepath.Join(workDir, "_cache")
+	for _, dir := range []string{"example.com/u/x/.git", "example.com/f/x/.hg"} {
+		err = os.MkdirAll(filepath.Join(root, filepath.FromSlash(dir)), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = ioutil.WriteFile(filepath.Join(root, "example.com", "u", "x", "README"), []byte("readme\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zipFile := filepath.Join(root, "proxy", "example.com", "x", "@v", "v1.0.0.zip")
+	err = os.MkdirAll(filepath.Dir(zipFile), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := os.Create(zipFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := zip.NewWriter(out)
+	w, err := archive.Create("example.com/x@v1.0.0/go.mod")
+	if err == nil {
+		_, err = w.Write([]byte("module example.com/x\n"))
+	}
+	if err == nil {
+		err = archive.Close()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return workDir
+}
+
+func TestListCache(t *testing.T) {
+	workDir := writeCache(t)
+	touchCache(filepath.Join(workDir, "_cache", "example.com", "u", "x"), "https://example.com/u/x", true, true)
+	if err := SaveCacheUsage(workDir); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := ListCache(workDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct{ path, kind, url string }{
+		{"example.com/f/x", "hg", ""},
+		{"example.com/u/x", "git", "https://example.com/u/x"},
+		{"proxy/example.com/x/@v/v1.0.0.zip", "zip", ""},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("got %d entries, want %d", len(entries), len(want))
+	}
+	for i, entry := range entries {
+		if entry.Path != want[i].path || entry.Kind != want[i].kind || entry.URL != want[i].url {
+			t.Errorf("entry %d is %s %s from %q, want %+v", i, entry.Path, entry.Kind, entry.URL, want[i])
+		}
+		if entry.LastUsed.IsZero() || entry.LastFetch.IsZero() {
+			t.Errorf("%s: no times", entry.Path)
+		}
+	}
+	if entries[1].Size != int64(len("readme\n")) {
+		t.Errorf("%s: size %d", entries[1].Path, entries[1].Size)
+	}
+	if err := entries[2].Verify(); err != nil {
+		t.Errorf("%s: %s", entries[2].Path, err)
+	}
+}
+
+func TestListCacheEmpty(t *testing.T) {
+	workDir, err := ioutil.TempDir("", "vcs-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workDir)
+	entries, err := ListCache(workDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("got %d entries in an empty cache", len(entries))
+	}
+}
+
+func TestCacheEntryRemove(t *testing.T) {
+	workDir := writeCache(t)
+	zipDir := filepath.Join(workDir, "_cache", "proxy", "example.com", "x", "@v")
+	touchCache(filepath.Join(zipDir, "v1.0.0.zip"), "https://proxy.example.com", true, true)
+	if err := SaveCacheUsage(workDir); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := ListCache(workDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entries[2].Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveCacheUsage(workDir); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(workDir, "_cache", "proxy")); !os.IsNotExist(err) {
+		t.Errorf("empty directories left behind: %v", err)
+	}
+	usage, err := readCacheUsage(workDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := usage["proxy/example.com/x/@v/v1.0.0.zip"]; ok {
+		t.Errorf("usage of the removed entry is still recorded")
+	}
+	entries, err = ListCache(workDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("got %d entries after removing one of 3", len(entries))
+	}
+}
+
+func TestVerifyZip(t *testing.T) {
+	workDir := writeCache(t)
+	filename := filepath.Join(workDir, "_cache", "proxy", "example.com", "x", "@v", "v1.0.0.zip")
+	if err := verifyZip(filename); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filename, []byte("not a zip"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyZip(filename); err == nil {
+		t.Errorf("expected an error for a broken zip")
+	}
+}
+
+func TestCacheEntryGC(t *testing.T) {
+	tr := newTestRepos(t)
+	entries, err := ListCache(tr.workDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want the upstream and the fork", len(entries))
+	}
+	for _, entry := range entries {
+		if err := entry.GC(false); err != nil {
+			t.Errorf("%s: %s", entry.Path, err)
+		}
+		if err := entry.Verify(); err != nil {
+			t.Errorf("%s: %s", entry.Path, err)
+		}
+	}
+}
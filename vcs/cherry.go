@@ -0,0 +1,406 @@
+package vcs
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/mod/semver"
+)
+
+const (
+	// CarryForkOnly marks a fork commit with no equivalent upstream
+	CarryForkOnly = "fork-only"
+
+	// CarryPartial marks a fork commit with some, but not all, of its
+	// file changes upstream
+	CarryPartial = "partially upstream"
+
+	// CarryEmpty marks a fork commit without changes of its own, such
+	// as a merge
+	CarryEmpty = "empty"
+
+	carryUpstreamPrefix = "also upstream in "
+)
+
+// CarrySummary counts the fork commits by how much of each is still
+// carried only in the fork
+type CarrySummary struct {
+	ForkOnly int `json:"fork_only"`
+	Partial  int `json:"partial"`
+	Upstream int `json:"upstream"`
+	Empty    int `json:"empty,omitempty"`
+}
+
+// Carried returns the number of commits that are not entirely upstream
+func (s CarrySummary) Carried() int {
+	return s.ForkOnly + s.Partial
+}
+
+func (s CarrySummary) String() string {
+	return fmt.Sprintf("%d commits still carried (%d fork-only, %d partially upstream), %d already upstream",
+		s.Carried(), s.ForkOnly, s.Partial, s.Upstream)
+}
+
+// IsUpstream reports whether the label says the whole commit is
+// upstream
+func IsUpstream(carry string) bool {
+	return strings.HasPrefix(carry, carryUpstreamPrefix)
+}
+
+// Summarize counts the commits by their carry label
+func Summarize(commits []Commit) CarrySummary {
+	var s CarrySummary
+	for _, c := range commits {
+		switch {
+		case c.Carry == CarryForkOnly:
+			s.ForkOnly++
+		case c.Carry == CarryPartial:
+			s.Partial++
+		case c.Carry == CarryEmpty:
+			s.Empty++
+		case IsUpstream(c.Carry):
+			s.Upstream++
+		}
+	}
+	return s
+}
+
+// upstreamTag is a release tag from the upstream repository
+type upstreamTag struct {
+	// name is the tag, including any module directory prefix
+	name string
+
+	// version is the module version the tag represents
+	version string
+
+	commit string
+}
+
+// upstreamTags returns the tags in the upstream repository for
+// versions after the old version, in semver order. They are read
+// from the cache of the upstream repository so that tags fetched
+// from the fork cannot be mistaken for upstream releases. For a
+// module in a subdirectory the tags with the directory prefix are
+// used, unless there are none.
+func (r *Repo) upstreamTags() ([]upstreamTag, error) {
+	out, err := gitOutput(r.cachePath(r.oldRepo), "for-each-ref",
+		"--format=%(refname:short) %(objectname) %(*objectname)", "refs/tags")
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("could not list tags of %s", r.oldRepo))
+	}
+
+	prefix := r.oldTagPrefix()
+	rootTags := []upstreamTag{}
+	prefixedTags := []upstreamTag{}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		tag := upstreamTag{name: fields[0], version: fields[0], commit: fields[1]}
+		if len(fields) > 2 {
+			// annotated tags give the commit they point to
+			tag.commit = fields[2]
+		}
+		prefixed := prefix != "" && strings.HasPrefix(tag.name, prefix)
+		if prefixed {
+			tag.version = strings.TrimPrefix(tag.name, prefix)
+		}
+		if !semver.IsValid(tag.version) {
+			continue
+		}
+		if semver.IsValid(r.oldVersion) && semver.Compare(tag.version, r.oldVersion) <= 0 {
+			continue
+		}
+		if prefixed {
+			prefixedTags = append(prefixedTags, tag)
+		} else {
+			rootTags = append(rootTags, tag)
+		}
+	}
+
+	tags := prefixedTags
+	if len(tags) == 0 {
+		tags = rootTags
+	}
+	sort.SliceStable(tags, func(i, j int) bool {
+		return semver.Compare(tags[i].version, tags[j].version) < 0
+	})
+	return tags, nil
+}
+
+// patchIDs returns the stable patch ids of the commits git log lists
+// with the options and the revisions, limited to the commits changing
+// the paths if there are any. The revisions and paths are passed on
+// stdin because there may be a lot of them, and the patches are
+// streamed into git patch-id instead of being held in memory. The
+// first result pairs the id of each whole commit with its hash, and
+// the second pairs the id of each file the commit changes with the
+// hash.
+func (r *Repo) patchIDs(options, revs, paths []string) ([][2]string, [][2]string, error) {
+	args := []string{"--no-pager", "--literal-pathspecs", "-C", r.localPath,
+		"log", "--patch", "--no-color", "--no-renames", "--pretty=format:commit %H"}
+	args = append(args, options...)
+	args = append(args, "--stdin")
+	input := strings.Join(revs, "\n") + "\n"
+	if len(paths) > 0 {
+		// Show the whole of every commit changing one of the paths,
+		// so its id is the same as that of an equivalent commit.
+		args = append(args, "--full-diff")
+		input += "--\n" + strings.Join(paths, "\n") + "\n"
+	}
+	logCmd := exec.Command("git", args...)
+	logCmd.Stdin = strings.NewReader(input)
+	var logStderr bytes.Buffer
+	logCmd.Stderr = &logStderr
+	patches, err := logCmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "could not read patches")
+	}
+
+	whole, err := startPatchID(r.localPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	files, err := startPatchID(r.localPath)
+	if err != nil {
+		whole.finish()
+		return nil, nil, err
+	}
+	err = logCmd.Start()
+	if err != nil {
+		whole.finish()
+		files.finish()
+		return nil, nil, errors.Wrap(err, "could not read patches")
+	}
+
+	// Give each file its own "commit" so patch-id computes an id for
+	// every file as well as for every commit.
+	reader := bufio.NewReader(patches)
+	commit := ""
+	var readErr, writeErr error
+	for readErr == nil && writeErr == nil {
+		var line string
+		line, readErr = reader.ReadString('\n')
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "commit "):
+			commit = line
+			_, writeErr = io.WriteString(whole.in, line)
+			continue
+		case strings.HasPrefix(line, "diff --git "):
+			_, writeErr = io.WriteString(files.in, commit)
+		}
+		if writeErr == nil {
+			_, writeErr = io.WriteString(whole.in, line)
+		}
+		if writeErr == nil {
+			_, writeErr = io.WriteString(files.in, line)
+		}
+	}
+	if readErr == io.EOF {
+		readErr = nil
+	}
+	if readErr != nil || writeErr != nil {
+		// git log would wait forever for the rest of its output to
+		// be read
+		logCmd.Process.Kill()
+	}
+	logErr := logCmd.Wait()
+	wholeIDs, wholeErr := whole.finish()
+	fileIDs, filesErr := files.finish()
+
+	switch {
+	case wholeErr != nil:
+		return nil, nil, wholeErr
+	case filesErr != nil:
+		return nil, nil, filesErr
+	case writeErr != nil:
+		return nil, nil, errors.Wrap(writeErr, "could not compute patch ids")
+	case readErr != nil:
+		return nil, nil, errors.Wrap(readErr, "could not read patches")
+	case logErr != nil:
+		return nil, nil, errors.Wrap(logErr, fmt.Sprintf("could not read patches: git log failed: %s",
+			strings.TrimSpace(logStderr.String())))
+	}
+	return wholeIDs, fileIDs, nil
+}
+
+// patchIDFilter is a git patch-id command reading patches as they are
+// written to it
+type patchIDFilter struct {
+	cmd    *exec.Cmd
+	in     io.WriteCloser
+	out    bytes.Buffer
+	stderr bytes.Buffer
+}
+
+// startPatchID runs git patch-id in the repository
+func startPatchID(dir string) (*patchIDFilter, error) {
+	f := &patchIDFilter{
+		cmd: exec.Command("git", "--no-pager", "-C", dir, "patch-id", "--stable"),
+	}
+	f.cmd.Stdout = &f.out
+	f.cmd.Stderr = &f.stderr
+	in, err := f.cmd.StdinPipe()
+	if err == nil {
+		err = f.cmd.Start()
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not compute patch ids")
+	}
+	f.in = in
+	return f, nil
+}
+
+// finish waits for patch-id to read the last patch and returns the
+// pairs of patch id and commit hash it computed
+func (f *patchIDFilter) finish() ([][2]string, error) {
+	f.in.Close()
+	err := f.cmd.Wait()
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("could not compute patch ids: git patch-id failed: %s",
+			strings.TrimSpace(f.stderr.String())))
+	}
+	result := [][2]string{}
+	for _, line := range strings.Split(f.out.String(), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		result = append(result, [2]string{fields[0], fields[1]})
+	}
+	return result, nil
+}
+
+// changedPaths returns the paths changed by the commits, or nil if
+// one of them cannot be passed to git log on a line of its own
+func (r *Repo) changedPaths(revs []string) ([]string, error) {
+	out, err := gitFilter(nil, strings.Join(revs, "\n")+"\n", r.localPath,
+		"log", "--no-walk=unsorted", "--no-renames", "--format=", "--name-only", "-z", "--stdin")
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read changed paths")
+	}
+	seen := map[string]bool{}
+	paths := []string{}
+	for _, path := range strings.Split(out, "\x00") {
+		path = strings.TrimLeft(path, "\n")
+		if path == "" || seen[path] {
+			continue
+		}
+		if strings.Contains(path, "\n") {
+			return nil, nil
+		}
+		seen[path] = true
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// firstRelease returns the name of the earliest tag containing the
+// upstream commit, or the default branch if it is not released yet
+func (r *Repo) firstRelease(commit string, tags []upstreamTag) string {
+	for _, tag := range tags {
+		err := r.git(false, "merge-base", "--is-ancestor", commit, tag.commit)
+		if err == nil {
+			return tag.name
+		}
+	}
+	return strings.TrimPrefix(r.upstreamBranch(), "origin/")
+}
+
+// ClassifyCommits labels each fork commit by comparing its patch id,
+// like git cherry does, with the upstream commits on the default
+// branch and in the releases after the old version. Without the git
+// command, or for other version control systems, the commits are left
+// unlabeled.
+func (r *Repo) ClassifyCommits(commits []Commit) error {
+	if len(commits) == 0 || !r.HasGitHistory() {
+		return nil
+	}
+
+	tags, err := r.upstreamTags()
+	if err != nil {
+		return err
+	}
+
+	forkRevs := []string{}
+	for _, c := range commits {
+		forkRevs = append(forkRevs, c.Hash)
+	}
+	forkWhole, forkFiles, err := r.patchIDs([]string{"--no-walk=unsorted"}, forkRevs, nil)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("could not read fork commits of %s", r.newRepo))
+	}
+	if len(forkWhole) == 0 {
+		for i := range commits {
+			commits[i].Carry = CarryEmpty
+		}
+		return nil
+	}
+
+	// Only the upstream commits changing the same files can have
+	// the same changes.
+	paths, err := r.changedPaths(forkRevs)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("could not read fork commits of %s", r.newRepo))
+	}
+
+	oldRef, _ := r.gitRefs()
+	upstreamRevs := []string{r.upstreamBranch(), fmt.Sprintf("^%s", oldRef)}
+	for _, tag := range tags {
+		upstreamRevs = append(upstreamRevs, tag.commit)
+	}
+	upstreamWhole, upstreamFiles, err := r.patchIDs([]string{"--no-merges"}, upstreamRevs, paths)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("could not read upstream commits of %s", r.oldRepo))
+	}
+
+	upstreamByID := map[string]string{}
+	for _, pair := range upstreamWhole {
+		if _, ok := upstreamByID[pair[0]]; !ok {
+			upstreamByID[pair[0]] = pair[1]
+		}
+	}
+	upstreamFileIDs := map[string]bool{}
+	for _, pair := range upstreamFiles {
+		upstreamFileIDs[pair[0]] = true
+	}
+
+	wholeByCommit := map[string]string{}
+	for _, pair := range forkWhole {
+		wholeByCommit[pair[1]] = pair[0]
+	}
+	filesByCommit := map[string][]string{}
+	for _, pair := range forkFiles {
+		filesByCommit[pair[1]] = append(filesByCommit[pair[1]], pair[0])
+	}
+
+	for i := range commits {
+		c := &commits[i]
+		id, ok := wholeByCommit[c.Hash]
+		if !ok {
+			c.Carry = CarryEmpty
+			continue
+		}
+		if upstream, ok := upstreamByID[id]; ok {
+			c.Carry = carryUpstreamPrefix + r.firstRelease(upstream, tags)
+			continue
+		}
+		c.Carry = CarryForkOnly
+		for _, fileID := range filesByCommit[c.Hash] {
+			if upstreamFileIDs[fileID] {
+				c.Carry = CarryPartial
+				break
+			}
+		}
+	}
+	return nil
+}
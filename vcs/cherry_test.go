@@ -0,0 +1,70 @@
+package vcs
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// newCarryRepos returns test repositories where the fork carries
+// commits that are upstream in a release, upstream on the default
+// branch only, partially upstream, only in the fork, and empty, in
+// that order
+func newCarryRepos(t *testing.T) *testRepos {
+	t.Helper()
+	tr := newTestRepos(t)
+
+	tr.commit(t, tr.upstream, "a.go", "package x // a\n", "upstream a")
+	tr.git(t, tr.upstream, "tag", "v1.1.0")
+	tr.commit(t, tr.upstream, "b.go", "package x // b\n", "upstream b")
+	tr.commit(t, tr.upstream, "c.go", "package x // c\n", "upstream c")
+
+	tr.commit(t, tr.fork, "a.go", "package x // a\n", "backport a")
+	tr.commit(t, tr.fork, "c.go", "package x // c\n", "backport c")
+	tr.commit(t, tr.fork, "fork.go", "package x // fork\n", "fork change")
+	err := ioutil.WriteFile(filepath.Join(tr.fork, "e.go"), []byte("package x // e\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr.git(t, tr.fork, "add", "e.go")
+	tr.commit(t, tr.fork, "b.go", "package x // b\n", "backport b with more")
+	tr.commits++
+	tr.git(t, tr.fork, "commit", "--quiet", "--allow-empty", "--message", "empty")
+	tr.git(t, tr.fork, "tag", "v1.0.0-fork")
+	return tr
+}
+
+func TestClassifyCommits(t *testing.T) {
+	tr := newCarryRepos(t)
+	r := tr.repo(t, "v1.0.0", "v1.0.0-fork")
+
+	commits, err := r.Commits()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.ClassifyCommits(commits)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"backport a":           "also upstream in v1.1.0",
+		"backport c":           "also upstream in main",
+		"fork change":          CarryForkOnly,
+		"backport b with more": CarryPartial,
+		"empty":                CarryEmpty,
+	}
+	if len(commits) != len(want) {
+		t.Fatalf("got %d commits, want %d: %+v", len(commits), len(want), commits)
+	}
+	for _, c := range commits {
+		if c.Carry != want[c.Subject] {
+			t.Errorf("%s: labeled %q, want %q", c.Subject, c.Carry, want[c.Subject])
+		}
+	}
+
+	summary := Summarize(commits)
+	if summary != (CarrySummary{ForkOnly: 1, Partial: 1, Upstream: 2, Empty: 1}) {
+		t.Errorf("summary %+v", summary)
+	}
+}
@@ -0,0 +1,141 @@
+package vcs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/mod/semver"
+)
+
+// Divergence measures how far a fork and its upstream have moved
+// apart since the merge base
+type Divergence struct {
+	// Ahead is the number of commits in the fork that are not in the
+	// old version
+	Ahead int `json:"ahead"`
+
+	// BehindHead is the number of upstream commits on the default
+	// branch since the merge base
+	BehindHead int `json:"behind_head"`
+
+	// LatestRelease is the newest upstream release after the old
+	// version, and BehindLatest the number of its commits since the
+	// merge base. Both are empty when the old version is the latest.
+	LatestRelease string `json:"latest_release,omitempty"`
+	BehindLatest  int    `json:"behind_latest"`
+
+	MergeBaseDate time.Time `json:"merge_base_date"`
+
+	// OldestCarry is the date of the oldest commit still carried
+	// only in the fork
+	OldestCarry *time.Time `json:"oldest_carry,omitempty"`
+}
+
+// CarryAge returns how long the oldest carried commit has been in the
+// fork
+func (d *Divergence) CarryAge(now time.Time) time.Duration {
+	if d.OldestCarry == nil {
+		return 0
+	}
+	return now.Sub(*d.OldestCarry)
+}
+
+func (d *Divergence) String() string {
+	result := fmt.Sprintf("ahead %d, behind %d on upstream HEAD", d.Ahead, d.BehindHead)
+	if d.LatestRelease != "" {
+		result += fmt.Sprintf(" and %d in %s", d.BehindLatest, d.LatestRelease)
+	}
+	result += fmt.Sprintf(", merge base from %s", d.MergeBaseDate.Format("2006-01-02"))
+	if d.OldestCarry != nil {
+		result += fmt.Sprintf(", oldest carried commit from %s (%d days)",
+			d.OldestCarry.Format("2006-01-02"), int(d.CarryAge(time.Now()).Hours()/24))
+	}
+	return result
+}
+
+// Divergence counts the commits on each side of the merge base. The
+// commits are the ones returned by Commits, and if they have been
+// classified only the ones still carried count towards the age of
+// the fork. It returns nil if the versions share no history or the
+// git history cannot be inspected.
+func (r *Repo) Divergence(commits []Commit) (*Divergence, error) {
+	if !r.HasGitHistory() {
+		return nil, nil
+	}
+	mergeBase := r.MergeBase()
+	if mergeBase == "" {
+		return nil, nil
+	}
+
+	d := &Divergence{Ahead: len(commits)}
+
+	out, err := r.gitOutput("log", "-1", "--pretty=format:%cI", mergeBase)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("could not read date of %s", mergeBase))
+	}
+	d.MergeBaseDate, err = time.Parse(time.RFC3339, strings.TrimSpace(out))
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("could not parse date of %s", mergeBase))
+	}
+
+	d.BehindHead, err = r.countCommits(mergeBase, r.upstreamBranch())
+	if err != nil {
+		return nil, err
+	}
+
+	tags, err := r.upstreamTags()
+	if err != nil {
+		return nil, err
+	}
+	if latest := latestRelease(tags); latest != nil {
+		d.LatestRelease = latest.name
+		d.BehindLatest, err = r.countCommits(mergeBase, latest.commit)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, c := range commits {
+		if IsUpstream(c.Carry) || c.Carry == CarryEmpty {
+			continue
+		}
+		if d.OldestCarry == nil || c.Date.Before(*d.OldestCarry) {
+			date := c.Date
+			d.OldestCarry = &date
+		}
+	}
+
+	return d, nil
+}
+
+// countCommits returns the number of commits reachable from to and not
+// from, limited to the module directory
+func (r *Repo) countCommits(from, to string) (int, error) {
+	args := []string{"rev-list", "--count", fmt.Sprintf("%s..%s", from, to)}
+	path := r.path()
+	if path != "" {
+		args = append(args, "--", path)
+	}
+	out, err := r.gitOutput(args...)
+	if err != nil {
+		return 0, errors.Wrap(err, fmt.Sprintf("could not count commits in %s..%s", from, to))
+	}
+	return strconv.Atoi(strings.TrimSpace(out))
+}
+
+// latestRelease returns the newest of the tags, which are in semver
+// order, preferring releases over pre-releases
+func latestRelease(tags []upstreamTag) *upstreamTag {
+	for i := len(tags) - 1; i >= 0; i-- {
+		if semver.Prerelease(tags[i].version) == "" {
+			return &tags[i]
+		}
+	}
+	if len(tags) > 0 {
+		return &tags[len(tags)-1]
+	}
+	return nil
+}
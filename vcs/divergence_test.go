@@ -0,0 +1,75 @@
+package vcs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDivergence(t *testing.T) {
+	tr := newCarryRepos(t)
+	r := tr.repo(t, "v1.0.0", "v1.0.0-fork")
+
+	commits, err := r.Commits()
+	if err != nil {
+		t.Fatal(err)
+	}
+	minute := func(m int) time.Time {
+		return time.Date(2020, 1, 1, 0, m, 0, 0, time.UTC)
+	}
+
+	d, err := r.Divergence(commits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d == nil {
+		t.Fatal("no divergence")
+	}
+	if d.Ahead != 5 || d.BehindHead != 3 || d.LatestRelease != "v1.1.0" || d.BehindLatest != 1 {
+		t.Errorf("divergence %+v", d)
+	}
+	if !d.MergeBaseDate.Equal(minute(1)) {
+		t.Errorf("merge base from %s, want %s", d.MergeBaseDate, minute(1))
+	}
+	// Without labels every commit counts as carried.
+	if d.OldestCarry == nil || !d.OldestCarry.Equal(minute(5)) {
+		t.Errorf("oldest carried commit from %v, want %s", d.OldestCarry, minute(5))
+	}
+
+	err = r.ClassifyCommits(commits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err = r.Divergence(commits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.OldestCarry == nil || !d.OldestCarry.Equal(minute(7)) {
+		t.Errorf("oldest carried commit from %v, want %s", d.OldestCarry, minute(7))
+	}
+	if age := d.CarryAge(minute(7).Add(48 * time.Hour)); age != 48*time.Hour {
+		t.Errorf("carry age %s", age)
+	}
+}
+
+func TestLatestRelease(t *testing.T) {
+	for _, tc := range []struct {
+		versions []string
+		want     string
+	}{
+		{nil, ""},
+		{[]string{"v1.1.0", "v1.2.0-rc.1"}, "v1.1.0"},
+		{[]string{"v1.2.0-rc.1", "v1.2.0-rc.2"}, "v1.2.0-rc.2"},
+	} {
+		tags := []upstreamTag{}
+		for _, v := range tc.versions {
+			tags = append(tags, upstreamTag{name: v, version: v})
+		}
+		got := ""
+		if latest := latestRelease(tags); latest != nil {
+			got = latest.name
+		}
+		if got != tc.want {
+			t.Errorf("latestRelease(%v) = %q, want %q", tc.versions, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package vcs
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// tool runs the version control operations for a repository that may
+// not use git. Unlike with git, the histories of the two repositories
+// cannot always be combined into one local clone, so every operation
+// names the directory to work in. Revisions are the identifiers
+// returned by Revision.
+type tool interface {
+	// Clone copies the repository at url, which may be the directory
+	// of another copy, to the new directory dir
+	Clone(verbose bool, prefix, url, dir string) error
+
+	// Pull adds the history of the copy in other to the one in dir
+	Pull(verbose bool, prefix, dir, other string) error
+
+	// Revision returns the identifier of the first of the candidate
+	// tags or commits that exists, or of the tip of the default branch
+	// if there are no candidates
+	Revision(dir string, candidates []string) (string, error)
+
+	// MergeBase returns the common ancestor of two revisions
+	MergeBase(dir, a, b string) (string, error)
+
+	// Log returns the commits in to but not in from, newest first.
+	// When path is not empty only commits changing something under it
+	// are included.
+	Log(dir, from, to, path string) ([]Commit, error)
+
+	// Export writes the files at the revision to the new directory
+	// dest
+	Export(dir, rev, dest string) error
+}
+
+// newTool returns the tool for the version control system named by
+// discovery, with git operations run by the backend
+func newTool(name string, backend Backend) (tool, error) {
+	switch name {
+	case "", "git":
+		return gitTool{backend: backend}, nil
+	case "hg":
+		return hgTool{}, nil
+	case "bzr":
+		return bzrTool{}, nil
+	case "svn":
+		return svnTool{}, nil
+	case "fossil":
+		return fossilTool{}, nil
+	}
+	return nil, fmt.Errorf("unsupported version control system %q", name)
+}
+
+// foreign reports whether the versions are compared without a
+// combined git clone, because either repository uses something other
+// than git or the modules come from a proxy
+func (r *Repo) foreign() bool {
+	return r.proxies != nil ||
+		(r.oldVCS != "" && r.oldVCS != "git") || (r.newVCS != "" && r.newVCS != "git")
+}
+
+// HasGitHistory reports whether the features that inspect the
+// combined git history, beyond the Backend interface, can be used
+func (r *Repo) HasGitHistory() bool {
+	return r.unavailable == nil && !r.foreign() && gitAvailable()
+}
+
+// vcsName returns the name used in messages for a version control
+// system
+func vcsName(name string) string {
+	if name == "" {
+		return "git"
+	}
+	return name
+}
+
+// TreeDiff explains why only the trees of the two versions can be
+// compared, or returns an empty string if their histories are
+// compared as well
+func (r *Repo) TreeDiff() string {
+	if r.unavailable != nil {
+		return ""
+	}
+	if r.proxies != nil {
+		return "the modules were downloaded from a proxy"
+	}
+	if !r.foreign() {
+		return ""
+	}
+	if vcsName(r.oldVCS) != vcsName(r.newVCS) {
+		return fmt.Sprintf("upstream uses %s and the fork uses %s",
+			vcsName(r.oldVCS), vcsName(r.newVCS))
+	}
+	if r.mergeBase == "" {
+		return fmt.Sprintf("the %s histories have no common ancestor", vcsName(r.oldVCS))
+	}
+	return ""
+}
+
+// revisionCandidates lists the tags or commit a module version may
+// refer to, with tags for a module in a subdirectory first
+func revisionCandidates(tagPrefix, version string) []string {
+	tag, commit := refFromVersion(version)
+	switch {
+	case tag == "" && commit == "":
+		return nil
+	case tag == "":
+		return []string{commit}
+	case tagPrefix != "":
+		return []string{tagPrefix + tag, tag}
+	}
+	return []string{tag}
+}
+
+// cloneForeign populates the caches with the tools for each
+// repository, combines the histories when both use the same system,
+// and resolves the versions
+func (r *Repo) cloneForeign(verbose bool) error {
+	upstream, err := newTool(r.oldVCS, r.backend)
+	if err != nil {
+		return errors.Wrap(err, r.oldRepo)
+	}
+	fork, err := newTool(r.newVCS, r.backend)
+	if err != nil {
+		return errors.Wrap(err, r.newRepo)
+	}
+
+	oldCachePath := r.cachePath(r.oldRepo)
+	err = cloneToCache(upstream, verbose, r.oldPath, oldCachePath, r.oldRepo)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to create cache of %s", r.oldRepo))
+	}
+	r.oldRev, err = upstream.Revision(oldCachePath,
+		revisionCandidates(r.oldTagPrefix(), r.oldVersion))
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("could not find %s in %s", r.oldVersion, r.oldRepo))
+	}
+
+	if r.localDir != "" {
+		// The working tree is compared as it is.
+		return nil
+	}
+
+	newCachePath := r.forkRepoDir()
+	err = cloneToCache(fork, verbose, r.oldPath, newCachePath, r.newRepo)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("failed to create cache of %s", r.newRepo))
+	}
+	r.newRev, err = fork.Revision(newCachePath,
+		revisionCandidates(r.newTagPrefix(), r.newVersion))
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("could not find %s in %s", r.newVersion, r.newRepo))
+	}
+
+	if vcsName(r.oldVCS) != vcsName(r.newVCS) {
+		log.Printf("%s: %s, comparing trees only", r.oldPath, r.TreeDiff())
+		return nil
+	}
+
+	// The local clone starts from the fork so the upstream history
+	// only has to be added to it.
+	if _, err := os.Stat(r.localPath); os.IsNotExist(err) {
+		err = os.MkdirAll(filepath.Dir(r.localPath), 0755)
+		if err != nil {
+			return errors.Wrap(err, "failed to create output directory for clone")
+		}
+		log.Printf("%s: cloning %s", r.oldPath, r.newRepo)
+		err = fork.Clone(verbose, r.oldPath, newCachePath, r.localPath)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("failed to clone %s", r.newRepo))
+		}
+	}
+	// The fork is pulled as well in case the module has been
+	// replaced by a different fork since the last run.
+	for _, other := range []string{newCachePath, oldCachePath} {
+		err = fork.Pull(verbose, r.oldPath, r.localPath, other)
+		if err != nil {
+			log.Printf("%s: could not combine the history of %s with %s: %s",
+				r.oldPath, r.oldRepo, r.newRepo, err)
+			return nil
+		}
+	}
+	r.mergeBase, err = fork.MergeBase(r.localPath, r.oldRev, r.newRev)
+	if errors.Cause(err) == errOffline {
+		return err
+	}
+	if err != nil {
+		log.Printf("%s: %s, comparing trees only", r.oldPath, r.TreeDiff())
+		r.mergeBase = ""
+	}
+	return nil
+}
+
+// foreignLog returns the commits in to but not in from from the
+// combined history in the local clone
+func (r *Repo) foreignLog(from, to string) ([]Commit, error) {
+	if r.mergeBase == "" {
+		return nil, nil
+	}
+	t, err := newTool(r.newVCS, r.backend)
+	if err != nil {
+		return nil, err
+	}
+	return t.Log(r.localPath, from, to, r.path())
+}
+
+// runTool runs a version control command in directory. When verbose,
+// the command and its output are shown on stderr, labeled with the
+// prefix.
+func runTool(verbose bool, prefix, directory, name string, args ...string) error {
+	if verbose {
+		log.Printf("%s: %s %s\n\n", prefix, name, strings.Join(args, " "))
+	}
+	cmd := exec.Command(name, args...)
+	cmd.Dir = directory
+	if verbose {
+		stdout := newPrefixWriter(prefix, os.Stderr)
+		defer stdout.Flush()
+		stderr := newPrefixWriter(prefix, os.Stderr)
+		defer stderr.Flush()
+		cmd.Stdout = stdout
+		cmd.Stderr = stderr
+	}
+	return cmd.Run()
+}
+
+// toolOutput runs a version control command in directory and returns
+// its output
+func toolOutput(directory, name string, args ...string) (string, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Dir = directory
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("%s %s failed: %s",
+			name, args[0], strings.TrimSpace(stderr.String())))
+	}
+	return string(out), nil
+}
+
+// isDir reports whether path is an existing directory
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
@@ -0,0 +1,172 @@
+package vcs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// fossilTool runs Fossil. A Fossil repository is a single file, so
+// each copy is a directory holding one.
+type fossilTool struct{}
+
+const (
+	// fossilFile is the name of the repository file in a copy
+	fossilFile = "repository.fossil"
+
+	// fossilDate is the layout of the dates in the timeline
+	fossilDate = "2006-01-02 15:04:05"
+)
+
+// fossilRepo returns the repository file for a copy, or the URL if it
+// is not a local directory
+func fossilRepo(url string) string {
+	if isDir(url) {
+		return filepath.Join(url, fossilFile)
+	}
+	return url
+}
+
+// Clone implements tool
+func (fossilTool) Clone(verbose bool, prefix, url, dir string) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	return runTool(verbose, prefix, dir, "fossil", "clone", fossilRepo(url), fossilRepo(dir))
+}
+
+// Pull implements tool. Only copies of the same project can be pulled
+// into each other.
+func (fossilTool) Pull(verbose bool, prefix, dir, other string) error {
+	return runTool(verbose, prefix, dir, "fossil", "pull", fossilRepo(other),
+		"-R", fossilRepo(dir))
+}
+
+// Revision implements tool
+func (fossilTool) Revision(dir string, candidates []string) (string, error) {
+	if len(candidates) == 0 {
+		candidates = []string{"trunk"}
+	}
+	for _, candidate := range candidates {
+		out, err := toolOutput(dir, "fossil", "info", candidate, "-R", fossilRepo(dir))
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(out, "\n") {
+			fields := strings.Fields(line)
+			// Older versions call the hash the uuid.
+			if len(fields) > 1 && (fields[0] == "hash:" || fields[0] == "uuid:") {
+				return fields[1], nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no revision %s", candidates[0])
+}
+
+// ancestors returns the check-ins leading up to and including rev,
+// newest first, limited to the ones changing path when it is not
+// empty
+func (fossilTool) ancestors(dir, rev, path string) ([]Commit, error) {
+	args := []string{"timeline", "ancestors", rev,
+		"-t", "ci", "-n", "0", "-W", "0",
+		"-F", fmt.Sprintf("%%H%[1]s%%d%[1]s%%a%[1]s%%c", fieldSep),
+		"-R", fossilRepo(dir)}
+	if path != "" {
+		args = append(args, "-p", path)
+	}
+	out, err := toolOutput(dir, "fossil", args...)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("could not read timeline of %s", rev))
+	}
+
+	commits := []Commit{}
+	for _, line := range strings.Split(out, "\n") {
+		// The timeline has date headings and a footer as well.
+		fields := strings.SplitN(strings.TrimSpace(line), fieldSep, 4)
+		if len(fields) != 4 {
+			continue
+		}
+		date, err := time.Parse(fossilDate, fields[1])
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("could not parse date of %s", fields[0]))
+		}
+		commits = append(commits, Commit{
+			Hash:    fields[0],
+			Date:    date,
+			Author:  fields[2],
+			Subject: fields[3],
+		})
+	}
+	return commits, nil
+}
+
+// MergeBase implements tool. The newest check-in that is an ancestor
+// of both is the common ancestor.
+func (t fossilTool) MergeBase(dir, a, b string) (string, error) {
+	ancestorsA, err := t.ancestors(dir, a, "")
+	if err != nil {
+		return "", err
+	}
+	ancestorsB, err := t.ancestors(dir, b, "")
+	if err != nil {
+		return "", err
+	}
+	inA := map[string]bool{}
+	for _, c := range ancestorsA {
+		inA[c.Hash] = true
+	}
+	for _, c := range ancestorsB {
+		if inA[c.Hash] {
+			return c.Hash, nil
+		}
+	}
+	return "", fmt.Errorf("%s and %s have no common ancestor", a, b)
+}
+
+// Log implements tool
+func (t fossilTool) Log(dir, from, to, path string) ([]Commit, error) {
+	excluded, err := t.ancestors(dir, from, "")
+	if err != nil {
+		return nil, err
+	}
+	inFrom := map[string]bool{}
+	for _, c := range excluded {
+		inFrom[c.Hash] = true
+	}
+
+	included, err := t.ancestors(dir, to, path)
+	if err != nil {
+		return nil, err
+	}
+	commits := []Commit{}
+	for _, c := range included {
+		if !inFrom[c.Hash] {
+			commits = append(commits, c)
+		}
+	}
+	return commits, nil
+}
+
+// Export implements tool
+func (fossilTool) Export(dir, rev, dest string) error {
+	archive, err := ioutil.TempFile("", "go-fork-diff-fossil-*.zip")
+	if err != nil {
+		return errors.Wrap(err, "could not create temporary archive")
+	}
+	archive.Close()
+	defer os.Remove(archive.Name())
+
+	const root = "export"
+	err = runTool(false, "", dir, "fossil", "zip", rev, archive.Name(),
+		"--name", root, "-R", fossilRepo(dir))
+	if err != nil {
+		return err
+	}
+	return extractZip(archive.Name(), root+"/", dest)
+}
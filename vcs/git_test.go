@@ -0,0 +1,109 @@
+package vcs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testRepos is an upstream repository and a fork of it, in the cache
+// of a working directory as if they had been cloned already
+type testRepos struct {
+	workDir  string
+	upstream string
+	fork     string
+
+	// commits counts the commits made, to give each a later date
+	commits int
+}
+
+// newTestRepos creates an upstream repository with one commit tagged
+// v1.0.0, and a fork of it, skipping the test without git
+func newTestRepos(t *testing.T) *testRepos {
+	t.Helper()
+	if !gitAvailable() {
+		t.Skip("the git command is not available")
+	}
+	workDir, err := ioutil.TempDir("", "vcs-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(workDir) })
+
+	tr := &testRepos{
+		workDir:  workDir,
+		upstream: filepath.Join(workDir, "_cache", "example.com", "u", "x"),
+		fork:     filepath.Join(workDir, "_cache", "example.com", "f", "x"),
+	}
+	err = os.MkdirAll(tr.upstream, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr.git(t, tr.upstream, "init", "--quiet")
+	tr.git(t, tr.upstream, "symbolic-ref", "HEAD", "refs/heads/main")
+	tr.commit(t, tr.upstream, "go.mod", "module example.com/u/x\n", "initial")
+	tr.git(t, tr.upstream, "tag", "v1.0.0")
+	tr.git(t, filepath.Dir(tr.fork), "clone", "--quiet", tr.upstream, "x")
+	return tr
+}
+
+// git runs the git command in dir, failing the test if it fails
+func (tr *testRepos) git(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	date := fmt.Sprintf("2020-01-01T00:%02d:00Z", tr.commits)
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+		"GIT_AUTHOR_DATE="+date, "GIT_COMMITTER_DATE="+date,
+		"GIT_CONFIG_NOSYSTEM=1", "HOME="+tr.workDir,
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %s: %s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// commit writes the file in the repository in dir and commits it,
+// returning the hash of the commit
+func (tr *testRepos) commit(t *testing.T, dir, filename, body, message string) string {
+	t.Helper()
+	tr.commits++
+	err := ioutil.WriteFile(filepath.Join(dir, filename), []byte(body), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr.git(t, dir, "add", filename)
+	tr.git(t, dir, "commit", "--quiet", "--message", message)
+	return tr.git(t, dir, "rev-parse", "HEAD")
+}
+
+// repo returns a Repo comparing the versions of the fork and upstream,
+// with the local clone made
+func (tr *testRepos) repo(t *testing.T, oldVersion, newVersion string) *Repo {
+	t.Helper()
+	r := &Repo{
+		workDir:    tr.workDir,
+		localPath:  localClonePath(tr.workDir, "example.com/u/x", "example.com/f/x"),
+		oldPath:    "example.com/u/x",
+		oldVersion: oldVersion,
+		oldRepo:    "https://example.com/u/x",
+		newPath:    "example.com/f/x",
+		newVersion: newVersion,
+		newRepo:    "https://example.com/f/x",
+		backend:    ExecBackend{},
+	}
+	err := r.Clone(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
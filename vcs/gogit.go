@@ -0,0 +1,465 @@
+package vcs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-billy/v5/osfs"
+	gogit "github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	// The caches and local clones are fetched from in process
+	// instead of by running git-upload-pack.
+	client.InstallProtocol("file", server.NewClient(worktreeLoader{}))
+}
+
+// worktreeLoader opens local repositories for the in-process file
+// transport, which only understands bare repositories by itself
+type worktreeLoader struct{}
+
+func (worktreeLoader) Load(ep *transport.Endpoint) (storer.Storer, error) {
+	for _, dir := range []string{filepath.Join(ep.Path, gogit.GitDirName), ep.Path} {
+		if _, err := os.Stat(filepath.Join(dir, "config")); err == nil {
+			return filesystem.NewStorage(osfs.New(dir), cache.NewObjectLRUDefault()), nil
+		}
+	}
+	return nil, transport.ErrRepositoryNotFound
+}
+
+// GoGitBackend implements the version control operations in Go, so
+// the git command does not need to be installed
+type GoGitBackend struct{}
+
+func progress(verbose bool, prefix string) (io.Writer, func()) {
+	if !verbose {
+		return nil, func() {}
+	}
+	w := newPrefixWriter(prefix, os.Stderr)
+	return w, func() { w.Flush() }
+}
+
+// Clone implements Backend
+func (GoGitBackend) Clone(verbose bool, prefix, url, dir string) error {
+	w, flush := progress(verbose, prefix)
+	defer flush()
+	_, err := gogit.PlainClone(dir, false, &gogit.CloneOptions{
+		URL:      url,
+		Tags:     gogit.AllTags,
+		Progress: w,
+	})
+	return err
+}
+
+// RemoteURL implements Backend
+func (GoGitBackend) RemoteURL(dir, remote string) (string, error) {
+	repo, err := gogit.PlainOpen(dir)
+	if err != nil {
+		return "", err
+	}
+	r, err := repo.Remote(remote)
+	if err == gogit.ErrRemoteNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return r.Config().URLs[0], nil
+}
+
+func fetchSpec(remote string) gitconfig.RefSpec {
+	return gitconfig.RefSpec(fmt.Sprintf("+refs/heads/*:refs/remotes/%s/*", remote))
+}
+
+// AddRemote implements Backend
+func (GoGitBackend) AddRemote(verbose bool, prefix, dir, remote, url string) error {
+	repo, err := gogit.PlainOpen(dir)
+	if err != nil {
+		return err
+	}
+	_, err = repo.CreateRemote(&gitconfig.RemoteConfig{
+		Name:  remote,
+		URLs:  []string{url},
+		Fetch: []gitconfig.RefSpec{fetchSpec(remote)},
+	})
+	return err
+}
+
+// SetRemoteURL implements Backend
+func (GoGitBackend) SetRemoteURL(verbose bool, prefix, dir, remote, url string) error {
+	repo, err := gogit.PlainOpen(dir)
+	if err != nil {
+		return err
+	}
+	cfg, err := repo.Config()
+	if err != nil {
+		return err
+	}
+	r, ok := cfg.Remotes[remote]
+	if !ok {
+		return fmt.Errorf("no remote %s", remote)
+	}
+	r.URLs = []string{url}
+	return repo.SetConfig(cfg)
+}
+
+// Fetch implements Backend
+func (GoGitBackend) Fetch(verbose bool, prefix, dir, remote string) error {
+	repo, err := gogit.PlainOpen(dir)
+	if err != nil {
+		return err
+	}
+	w, flush := progress(verbose, prefix)
+	defer flush()
+	err = repo.Fetch(&gogit.FetchOptions{
+		RemoteName: remote,
+		RefSpecs:   []gitconfig.RefSpec{fetchSpec(remote)},
+		Tags:       gogit.AllTags,
+		Progress:   w,
+		Force:      true,
+	})
+	if err == gogit.NoErrAlreadyUpToDate {
+		return nil
+	}
+	return err
+}
+
+// DefaultBranch implements Backend
+func (GoGitBackend) DefaultBranch(verbose bool, prefix, dir, remote string) (string, error) {
+	repo, err := gogit.PlainOpen(dir)
+	if err != nil {
+		return "", err
+	}
+	r, err := repo.Remote(remote)
+	if err != nil {
+		return "", err
+	}
+
+	var head *plumbing.Reference
+	url := r.Config().URLs[0]
+	if other, err := gogit.PlainOpen(url); err == nil {
+		// A local repository can be asked directly.
+		head, err = other.Storer.Reference(plumbing.HEAD)
+		if err != nil {
+			return "", err
+		}
+	} else {
+		refs, err := r.List(&gogit.ListOptions{})
+		if err != nil {
+			return "", err
+		}
+		for _, ref := range refs {
+			if ref.Name() == plumbing.HEAD {
+				head = ref
+				break
+			}
+		}
+	}
+	if head == nil || head.Type() != plumbing.SymbolicReference {
+		return "", fmt.Errorf("could not find HEAD of %s", remote)
+	}
+
+	branch := head.Target().Short()
+	err = repo.Storer.SetReference(plumbing.NewSymbolicReference(
+		plumbing.ReferenceName(fmt.Sprintf("refs/remotes/%s/HEAD", remote)),
+		plumbing.NewRemoteReferenceName(remote, branch),
+	))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/%s", remote, branch), nil
+}
+
+// resolveCommit finds the commit for a revision, allowing the forms
+// the rest of the package uses
+func resolveCommit(repo *gogit.Repository, rev string) (*object.Commit, error) {
+	rev = strings.TrimSuffix(rev, "^{commit}")
+	hash, err := repo.ResolveRevision(plumbing.Revision(rev))
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("could not find %s", rev))
+	}
+	return repo.CommitObject(*hash)
+}
+
+// RevParse implements Backend
+func (GoGitBackend) RevParse(dir, rev string) (string, error) {
+	repo, err := gogit.PlainOpen(dir)
+	if err != nil {
+		return "", err
+	}
+	commit, err := resolveCommit(repo, rev)
+	if err != nil {
+		return "", err
+	}
+	return commit.Hash.String(), nil
+}
+
+// TopLevel implements Backend
+func (GoGitBackend) TopLevel(dir string) (string, error) {
+	repo, err := gogit.PlainOpenWithOptions(dir, &gogit.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		return "", err
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return "", err
+	}
+	return worktree.Filesystem.Root(), nil
+}
+
+// revisionFile returns the file at path in the tree of the revision,
+// or nil if there is no file there
+func revisionFile(dir, rev, path string) (*object.File, error) {
+	repo, err := gogit.PlainOpen(dir)
+	if err != nil {
+		return nil, err
+	}
+	commit, err := resolveCommit(repo, rev)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("could not read tree of %s", rev))
+	}
+	file, err := tree.File(path)
+	if err == object.ErrFileNotFound || err == object.ErrDirectoryNotFound {
+		return nil, nil
+	}
+	return file, err
+}
+
+// HasFile implements Backend
+func (GoGitBackend) HasFile(dir, rev, path string) (bool, error) {
+	file, err := revisionFile(dir, rev, path)
+	return file != nil, err
+}
+
+// ReadFile implements Backend
+func (GoGitBackend) ReadFile(dir, rev, path string) ([]byte, error) {
+	file, err := revisionFile(dir, rev, path)
+	if err != nil {
+		return nil, err
+	}
+	if file == nil {
+		return nil, fmt.Errorf("%s does not exist in %s", path, rev)
+	}
+	contents, err := file.Contents()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(contents), nil
+}
+
+// MergeBase implements Backend
+func (GoGitBackend) MergeBase(dir, a, b string) (string, error) {
+	repo, err := gogit.PlainOpen(dir)
+	if err != nil {
+		return "", err
+	}
+	commitA, err := resolveCommit(repo, a)
+	if err != nil {
+		return "", err
+	}
+	commitB, err := resolveCommit(repo, b)
+	if err != nil {
+		return "", err
+	}
+	bases, err := commitA.MergeBase(commitB)
+	if err != nil {
+		return "", err
+	}
+	if len(bases) == 0 {
+		return "", fmt.Errorf("%s and %s have no common ancestor", a, b)
+	}
+	return bases[0].Hash.String(), nil
+}
+
+// Log implements Backend
+func (GoGitBackend) Log(dir, from, to, path string) ([]Commit, error) {
+	repo, err := gogit.PlainOpen(dir)
+	if err != nil {
+		return nil, err
+	}
+	fromCommit, err := resolveCommit(repo, from)
+	if err != nil {
+		return nil, err
+	}
+	toCommit, err := resolveCommit(repo, to)
+	if err != nil {
+		return nil, err
+	}
+
+	excluded := map[plumbing.Hash]bool{}
+	err = object.NewCommitPreorderIter(fromCommit, nil, nil).ForEach(func(c *object.Commit) error {
+		excluded[c.Hash] = true
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("could not read history of %s", from))
+	}
+
+	commits := []Commit{}
+	err = object.NewCommitPreorderIter(toCommit, excluded, nil).ForEach(func(c *object.Commit) error {
+		if path != "" {
+			changed, err := changesPath(c, path)
+			if err != nil || !changed {
+				return err
+			}
+		}
+		commits = append(commits, Commit{
+			Hash:    c.Hash.String(),
+			Date:    c.Committer.When,
+			Author:  fmt.Sprintf("%s <%s>", c.Author.Name, c.Author.Email),
+			Subject: strings.SplitN(strings.TrimSpace(c.Message), "\n", 2)[0],
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("could not read log of %s..%s", from, to))
+	}
+
+	sort.SliceStable(commits, func(i, j int) bool {
+		return commits[i].Date.After(commits[j].Date)
+	})
+	return commits, nil
+}
+
+// changesPath reports whether the commit changes anything under path
+// compared with every one of its parents, like the history
+// simplification git log does for a path
+func changesPath(c *object.Commit, path string) (bool, error) {
+	hash, err := pathHash(c, path)
+	if err != nil {
+		return false, err
+	}
+	if c.NumParents() == 0 {
+		return !hash.IsZero(), nil
+	}
+	treeSame := false
+	err = c.Parents().ForEach(func(parent *object.Commit) error {
+		parentHash, err := pathHash(parent, path)
+		if err != nil {
+			return err
+		}
+		if parentHash == hash {
+			treeSame = true
+			return storer.ErrStop
+		}
+		return nil
+	})
+	return !treeSame, err
+}
+
+// pathHash returns the hash of the tree or file at path in the commit,
+// or the zero hash if there is nothing there
+func pathHash(c *object.Commit, path string) (plumbing.Hash, error) {
+	tree, err := c.Tree()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	entry, err := tree.FindEntry(path)
+	if err == object.ErrEntryNotFound || err == object.ErrDirectoryNotFound {
+		return plumbing.ZeroHash, nil
+	}
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return entry.Hash, nil
+}
+
+// matchPathspec reports whether the file is selected by the pathspec
+func matchPathspec(pathspec []string, name string) bool {
+	under := func(dir string) bool {
+		return dir == "." || name == dir || strings.HasPrefix(name, dir+"/")
+	}
+	included := false
+	for _, spec := range pathspec {
+		if strings.HasPrefix(spec, ":!") {
+			if under(strings.TrimPrefix(spec, ":!")) {
+				return false
+			}
+			continue
+		}
+		if under(spec) {
+			included = true
+		}
+	}
+	return included
+}
+
+// Diff implements Backend
+func (GoGitBackend) Diff(dir, from, to string, pathspec []string, patches bool) ([]FileStat, error) {
+	repo, err := gogit.PlainOpen(dir)
+	if err != nil {
+		return nil, err
+	}
+	trees := []*object.Tree{}
+	for _, rev := range []string{from, to} {
+		commit, err := resolveCommit(repo, rev)
+		if err != nil {
+			return nil, err
+		}
+		tree, err := commit.Tree()
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("could not read tree of %s", rev))
+		}
+		trees = append(trees, tree)
+	}
+
+	changes, err := object.DiffTree(trees[0], trees[1])
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("could not read diff of %s..%s", from, to))
+	}
+
+	stats := []FileStat{}
+	for _, change := range changes {
+		name := change.To.Name
+		if name == "" {
+			name = change.From.Name
+		}
+		if !matchPathspec(pathspec, name) {
+			continue
+		}
+
+		patch, err := change.Patch()
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("could not read diff of %s", name))
+		}
+		stat := FileStat{Path: name}
+		for _, filePatch := range patch.FilePatches() {
+			if filePatch.IsBinary() {
+				stat.Binary = true
+			}
+		}
+		if !stat.Binary {
+			for _, fileStat := range patch.Stats() {
+				stat.Added += fileStat.Addition
+				stat.Deleted += fileStat.Deletion
+			}
+		}
+		if patches {
+			stat.Patch = patch.String()
+		}
+		stats = append(stats, stat)
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Path < stats[j].Path
+	})
+	return stats, nil
+}
@@ -0,0 +1,113 @@
+package vcs
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// hgTool runs Mercurial
+type hgTool struct{}
+
+// hgSymbol quotes a tag, branch or hash prefix so it is looked up as a
+// name instead of being parsed as a revset
+func hgSymbol(name string) string {
+	return fmt.Sprintf("'%s'", strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(name))
+}
+
+// Clone implements tool. The copies have no working directory because
+// the files are only ever exported.
+func (hgTool) Clone(verbose bool, prefix, url, dir string) error {
+	return runTool(verbose, prefix, filepath.Dir(dir), "hg", "clone", "--noupdate", url, dir)
+}
+
+// Pull implements tool. Unrelated repositories are pulled too, and
+// then have no common ancestor.
+func (hgTool) Pull(verbose bool, prefix, dir, other string) error {
+	return runTool(verbose, prefix, dir, "hg", "pull", "--force", other)
+}
+
+// Revision implements tool
+func (hgTool) Revision(dir string, candidates []string) (string, error) {
+	if len(candidates) == 0 {
+		candidates = []string{"default"}
+	}
+	for _, candidate := range candidates {
+		out, err := toolOutput(dir, "hg", "log", "--repository", dir,
+			"--rev", hgSymbol(candidate), "--template", "{node}")
+		if err == nil && strings.TrimSpace(out) != "" {
+			return strings.TrimSpace(out), nil
+		}
+	}
+	return "", fmt.Errorf("no revision %s", candidates[0])
+}
+
+// MergeBase implements tool
+func (hgTool) MergeBase(dir, a, b string) (string, error) {
+	out, err := toolOutput(dir, "hg", "log", "--repository", dir,
+		"--rev", fmt.Sprintf("ancestor(%s, %s)", a, b), "--template", "{node}")
+	if err != nil {
+		return "", err
+	}
+	base := strings.TrimSpace(out)
+	if base == "" {
+		return "", fmt.Errorf("%s and %s have no common ancestor", a, b)
+	}
+	return base, nil
+}
+
+// Log implements tool
+func (hgTool) Log(dir, from, to, path string) ([]Commit, error) {
+	args := []string{
+		"log", "--repository", dir,
+		"--rev", fmt.Sprintf("only(%s, %s)", to, from),
+		"--template", fmt.Sprintf("{node}%[1]s{date|rfc3339date}%[1]s{author}%[1]s{desc|firstline}%[2]s",
+			fieldSep, recordSep),
+	}
+	if path != "" {
+		args = append(args, fmt.Sprintf("path:%s", path))
+	}
+
+	out, err := toolOutput(dir, "hg", args...)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("could not read log of %s..%s", from, to))
+	}
+
+	commits := []Commit{}
+	for _, record := range strings.Split(out, recordSep) {
+		record = strings.TrimSpace(record)
+		if record == "" {
+			continue
+		}
+		fields := strings.SplitN(record, fieldSep, 4)
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("could not parse log entry %q", record)
+		}
+		date, err := time.Parse(time.RFC3339, fields[1])
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("could not parse date of %s", fields[0]))
+		}
+		commits = append(commits, Commit{
+			Hash:    fields[0],
+			Date:    date,
+			Author:  fields[2],
+			Subject: fields[3],
+		})
+	}
+
+	// Revsets list the oldest changesets first.
+	sort.SliceStable(commits, func(i, j int) bool {
+		return commits[i].Date.After(commits[j].Date)
+	})
+	return commits, nil
+}
+
+// Export implements tool
+func (hgTool) Export(dir, rev, dest string) error {
+	return runTool(false, "", dir, "hg", "--config", "ui.archivemeta=false",
+		"archive", "--repository", dir, "--rev", rev, "--type", "files", dest)
+}
@@ -0,0 +1,208 @@
+package vcs
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Commit describes one commit in the fork range
+type Commit struct {
+	Hash    string    `json:"hash"`
+	Date    time.Time `json:"date"`
+	Author  string    `json:"author"`
+	Subject string    `json:"subject"`
+
+	// Carry says whether the change is still only in the fork, once
+	// ClassifyCommits has been called
+	Carry string `json:"carry,omitempty"`
+}
+
+// FileStat holds the diff statistics for one file
+type FileStat struct {
+	Path    string `json:"path"`
+	Added   int    `json:"added"`
+	Deleted int    `json:"deleted"`
+	Binary  bool   `json:"binary,omitempty"`
+
+	// Patch holds the unified diff for the file, when requested
+	Patch string `json:"patch,omitempty"`
+}
+
+// ResolveRefs returns the commit hashes for the old and new versions.
+// A version that cannot be found in the local clone gives an empty
+// string.
+func (r *Repo) ResolveRefs() (string, string) {
+	if r.unavailable != nil {
+		return "", ""
+	}
+	if r.foreign() {
+		return r.oldRev, r.newRev
+	}
+	oldRef, newRef := r.gitRefs()
+	return r.resolveRef(oldRef), r.resolveRef(newRef)
+}
+
+func (r *Repo) resolveRef(ref string) string {
+	hash, err := r.backend.RevParse(r.localPath, ref)
+	if err != nil {
+		return ""
+	}
+	return hash
+}
+
+// MergeBase returns the best common ancestor of the two versions, or
+// an empty string if they do not share any history
+func (r *Repo) MergeBase() string {
+	if r.unavailable != nil {
+		return ""
+	}
+	if r.foreign() {
+		return r.mergeBase
+	}
+	oldRef, newRef := r.gitRefs()
+	hash, err := r.backend.MergeBase(r.localPath, oldRef, newRef)
+	if err != nil {
+		return ""
+	}
+	return hash
+}
+
+// Commits returns the commits in the new version that are not in the
+// old version
+func (r *Repo) Commits() ([]Commit, error) {
+	if r.unavailable != nil {
+		return nil, nil
+	}
+	if r.foreign() {
+		return r.foreignLog(r.oldRev, r.newRev)
+	}
+	if !r.commonAncestor() {
+		return nil, nil
+	}
+	oldRef, newRef := r.gitRefs()
+	return r.backend.Log(r.localPath, oldRef, newRef, r.path())
+}
+
+// DroppedCommits returns the commits in the old version that are not
+// in the new version
+func (r *Repo) DroppedCommits() ([]Commit, error) {
+	if r.unavailable != nil {
+		return nil, nil
+	}
+	if r.foreign() {
+		return r.foreignLog(r.newRev, r.oldRev)
+	}
+	if !r.commonAncestor() {
+		return nil, nil
+	}
+	oldRef, newRef := r.gitRefs()
+	return r.backend.Log(r.localPath, newRef, oldRef, r.path())
+}
+
+// DiffStats returns the per-file diff statistics between the two
+// versions
+func (r *Repo) DiffStats() ([]FileStat, error) {
+	return r.diff(false)
+}
+
+// Patches returns the unified diff between the two versions, split
+// up by the path of the file
+func (r *Repo) Patches() (map[string]string, error) {
+	stats, err := r.diff(true)
+	if err != nil {
+		return nil, err
+	}
+	patches := map[string]string{}
+	for _, stat := range stats {
+		patches[stat.Path] = stat.Patch
+	}
+	return patches, nil
+}
+
+// diff returns the changes to each file between the two versions,
+// including the patches when requested. When either repository does
+// not use git the trees are compared instead.
+func (r *Repo) diff(patches bool) ([]FileStat, error) {
+	if r.unavailable != nil {
+		return nil, nil
+	}
+	if r.foreign() {
+		stats, err := r.treeDiff()
+		if err != nil || patches {
+			return stats, err
+		}
+		result := make([]FileStat, len(stats))
+		for i, stat := range stats {
+			result[i] = stat
+			result[i].Patch = ""
+		}
+		return result, nil
+	}
+
+	if !r.commonAncestor() {
+		return nil, nil
+	}
+	oldRef, newRef := r.gitRefs()
+	return r.backend.Diff(r.localPath, oldRef, newRef, r.diffPathspec(), patches)
+}
+
+// FormatPatches writes the commits in the new version that are not in
+// the old version to dir as a series of patch files, oldest first, and
+// returns the names of the files
+func (r *Repo) FormatPatches(dir string) ([]string, error) {
+	if r.unavailable != nil {
+		return nil, r.unavailable
+	}
+	if r.proxies != nil {
+		return nil, errors.New("patches cannot be exported from module zips")
+	}
+	if r.foreign() {
+		return nil, fmt.Errorf("patches can only be exported from git repositories, but upstream uses %s and the fork uses %s",
+			r.OldVCS(), r.NewVCS())
+	}
+	if !gitAvailable() {
+		return nil, errNoGit
+	}
+	if !r.commonAncestor() {
+		return nil, nil
+	}
+
+	args := []string{"format-patch", "--no-color", "--output-directory", dir, r.gitRange()}
+	path := r.path()
+	if path != "" {
+		args = append(args, "--", path)
+	}
+
+	out, err := r.gitOutput(args...)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("could not format patches for %s", r.gitRange()))
+	}
+
+	names := []string{}
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		names = append(names, filepath.Base(line))
+	}
+	return names, nil
+}
+
+// ShowFile returns the contents of a file at ref in the repository in
+// directory, read by the backend
+func ShowFile(backend Backend, directory, ref, filename string) ([]byte, error) {
+	out, err := backend.ReadFile(directory, ref, filename)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("could not read %s at %s", filename, ref))
+	}
+	return out, nil
+}
+
+func (r *Repo) gitOutput(args ...string) (string, error) {
+	return gitOutput(r.localPath, args...)
+}
@@ -0,0 +1,130 @@
+package vcs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// NewLocal creates a new Repo for a replacement pointing at a
+// directory on the local filesystem. The directory must be inside a
+// git repository, and its working tree, including uncommitted
+// changes, is compared with the old version. The backend finds the
+// repository.
+func NewLocal(workDir, oldPath, oldVersion, localDir string, repoAliases []Alias, backend Backend) (*Repo, error) {
+	localDir, err := filepath.Abs(localDir)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not find absolute path of replacement directory")
+	}
+
+	topLevel, err := backend.TopLevel(localDir)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("could not find git repository for %s", localDir))
+	}
+
+	subdir, err := filepath.Rel(topLevel, localDir)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not find replacement directory within its repository")
+	}
+	if subdir == "." {
+		subdir = ""
+	}
+
+	repo := Repo{
+		workDir:     workDir,
+		localPath:   localClonePath(workDir, oldPath, localDir),
+		oldPath:     oldPath,
+		oldVersion:  oldVersion,
+		newPath:     localDir,
+		newRepo:     topLevel,
+		localDir:    localDir,
+		localSubdir: filepath.ToSlash(subdir),
+		backend:     backend,
+	}
+
+	err = repo.resolveOld(repoAliases)
+	if repo.markUnavailable(err) {
+		return &repo, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &repo, nil
+}
+
+// snapshotWorkTree records the current contents of the working tree
+// of the local fork, including changes that have not been committed,
+// as a commit in the local clone so it can be logged and diffed like
+// any other version. It needs the git command to build the commit.
+func (r *Repo) snapshotWorkTree(verbose bool) error {
+	if !gitAvailable() {
+		return errors.Wrap(errNoGit, fmt.Sprintf("could not include the working tree of %s", r.newRepo))
+	}
+	err := r.gitLogged(verbose, "fetch", remoteName, "HEAD")
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("could not update remote %s", r.newRepo))
+	}
+	head, err := r.gitOutput("rev-parse", "FETCH_HEAD")
+	if err != nil {
+		return err
+	}
+	head = strings.TrimSpace(head)
+
+	// Build the tree in a temporary index so neither repository's
+	// own index is disturbed.
+	index, err := ioutil.TempFile("", "go-fork-diff-index-")
+	if err != nil {
+		return errors.Wrap(err, "could not create temporary index")
+	}
+	index.Close()
+	os.Remove(index.Name())
+	defer os.Remove(index.Name())
+
+	env := []string{
+		fmt.Sprintf("GIT_INDEX_FILE=%s", index.Name()),
+		fmt.Sprintf("GIT_DIR=%s", filepath.Join(r.localPath, ".git")),
+		fmt.Sprintf("GIT_WORK_TREE=%s", r.newRepo),
+	}
+	_, err = gitOutputEnv(env, r.newRepo, "add", "--all", "--", ".")
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("could not read working tree of %s", r.newRepo))
+	}
+	tree, err := gitOutputEnv(env, r.newRepo, "write-tree")
+	if err != nil {
+		return err
+	}
+	tree = strings.TrimSpace(tree)
+
+	headTree, err := r.gitOutput("rev-parse", fmt.Sprintf("%s^{tree}", head))
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(headTree) == tree {
+		if verbose {
+			log.Printf("%s: no uncommitted changes in %s", r.oldPath, r.newRepo)
+		}
+		r.snapshot = head
+		return nil
+	}
+
+	log.Printf("%s: including uncommitted changes in %s", r.oldPath, r.newRepo)
+	env = []string{
+		"GIT_AUTHOR_NAME=go-fork-diff",
+		"GIT_AUTHOR_EMAIL=go-fork-diff@localhost",
+		"GIT_COMMITTER_NAME=go-fork-diff",
+		"GIT_COMMITTER_EMAIL=go-fork-diff@localhost",
+	}
+	commit, err := gitOutputEnv(env, r.localPath, "commit-tree", tree, "-p", head,
+		"-m", fmt.Sprintf("Uncommitted changes in %s", r.newRepo))
+	if err != nil {
+		return err
+	}
+	r.snapshot = strings.TrimSpace(commit)
+	return nil
+}
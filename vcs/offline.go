@@ -0,0 +1,105 @@
+package vcs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/dhellmann/go-fork-diff/discovery"
+	"github.com/pkg/errors"
+)
+
+// offline is set when nothing may be fetched over the network
+var offline bool
+
+// errOffline is the cause of every failure to find something locally
+// when offline
+var errOffline = errors.New("unavailable offline")
+
+// savedRoots holds the repositories found for import paths by earlier
+// runs, read by LoadResolutions
+var savedRoots = map[string]*discovery.RepoRoot{}
+
+// SetOffline stops all use of the network. Import paths are resolved
+// from the answers saved by earlier runs, and only the repositories
+// and module zips already in the cache, or in the module cache, are
+// compared. Anything else is marked unavailable instead of failing.
+func SetOffline() {
+	offline = true
+}
+
+// resolutionsFile is where the repositories found for import paths
+// are kept between runs
+func resolutionsFile(workDir string) string {
+	return filepath.Join(workDir, "_cache", "resolutions.json")
+}
+
+// LoadResolutions reads the repositories found for import paths by
+// earlier runs with the same working directory. They are only used in
+// place of discovery when offline.
+func LoadResolutions(workDir string) error {
+	filename := resolutionsFile(workDir)
+	body, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("could not read %s", filename))
+	}
+
+	roots := map[string]*discovery.RepoRoot{}
+	err = json.Unmarshal(body, &roots)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("could not parse %s", filename))
+	}
+
+	resolveLock.Lock()
+	defer resolveLock.Unlock()
+	savedRoots = roots
+	return nil
+}
+
+// SaveResolutions adds the repositories found for import paths during
+// this run to the ones saved by earlier runs, so they can be used
+// offline later
+func SaveResolutions(workDir string) error {
+	resolveLock.Lock()
+	changed := false
+	for importPath, root := range resolveCache {
+		if _, ok := savedRoots[importPath]; !ok {
+			changed = true
+		}
+		savedRoots[importPath] = root
+	}
+	body, err := json.MarshalIndent(savedRoots, "", "  ")
+	resolveLock.Unlock()
+	if err != nil || !changed {
+		return err
+	}
+
+	return writeFileAtomic(resolutionsFile(workDir), append(body, '\n'))
+}
+
+// Unavailable explains why the versions could not be compared while
+// offline, or returns an empty string if they are available
+func (r *Repo) Unavailable() string {
+	if r.unavailable == nil {
+		return ""
+	}
+	return r.unavailable.Error()
+}
+
+// markUnavailable records err as the reason the repository cannot be
+// compared if it comes from something missing while offline, and
+// reports whether it did
+func (r *Repo) markUnavailable(err error) bool {
+	if !offline || errors.Cause(err) != errOffline {
+		return false
+	}
+	r.unavailable = err
+	log.Printf("%s: %s", r.oldPath, err)
+	return true
+}
@@ -0,0 +1,133 @@
+package vcs
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/dhellmann/go-fork-diff/discovery"
+	"github.com/pkg/errors"
+)
+
+// setOfflineForTest turns offline mode on, with no resolutions, until
+// the test ends
+func setOfflineForTest(t *testing.T) {
+	t.Helper()
+	resolveLock.Lock()
+	oldCache, oldSaved := resolveCache, savedRoots
+	resolveCache, savedRoots = map[string]*discovery.RepoRoot{}, map[string]*discovery.RepoRoot{}
+	resolveLock.Unlock()
+	offline = true
+	t.Cleanup(func() {
+		offline = false
+		resolveLock.Lock()
+		resolveCache, savedRoots = oldCache, oldSaved
+		resolveLock.Unlock()
+	})
+}
+
+func TestResolutions(t *testing.T) {
+	workDir, err := ioutil.TempDir("", "vcs-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workDir)
+	setOfflineForTest(t)
+
+	// Nothing saved yet
+	if err := LoadResolutions(workDir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := resolveOne("example.com/x"); errors.Cause(err) != errOffline {
+		t.Errorf("resolved example.com/x without a saved answer: %v", err)
+	}
+
+	want := &discovery.RepoRoot{Root: "https://example.com/x", Prefix: "example.com/x", VCS: "git"}
+	resolveLock.Lock()
+	resolveCache["example.com/x"] = want
+	resolveLock.Unlock()
+	if err := SaveResolutions(workDir); err != nil {
+		t.Fatal(err)
+	}
+
+	resolveLock.Lock()
+	resolveCache, savedRoots = map[string]*discovery.RepoRoot{}, map[string]*discovery.RepoRoot{}
+	resolveLock.Unlock()
+	if err := LoadResolutions(workDir); err != nil {
+		t.Fatal(err)
+	}
+	root, err := resolveOne("example.com/x/sub")
+	if errors.Cause(err) != errOffline {
+		t.Errorf("resolved example.com/x/sub to %+v, %v", root, err)
+	}
+	root, err = resolveOne("example.com/x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *root != *want {
+		t.Errorf("resolved %+v, want %+v", root, want)
+	}
+
+	// github paths need no lookup
+	root, err = resolveOne("github.com/o/r/sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.Root != "https://github.com/o/r" {
+		t.Errorf("resolved %+v, want https://github.com/o/r", root)
+	}
+}
+
+func TestCloneOffline(t *testing.T) {
+	tr := newTestRepos(t)
+	setOfflineForTest(t)
+
+	// Both repositories are in the cache
+	r := tr.repo(t, "v1.0.0", "v1.0.0")
+	if r.Unavailable() != "" {
+		t.Errorf("cached repositories unavailable: %s", r.Unavailable())
+	}
+
+	r = &Repo{
+		workDir:    tr.workDir,
+		localPath:  localClonePath(tr.workDir, "example.com/u/x", "example.com/missing/x"),
+		oldPath:    "example.com/u/x",
+		oldVersion: "v1.0.0",
+		oldRepo:    "https://example.com/u/x",
+		newPath:    "example.com/missing/x",
+		newVersion: "v1.0.0",
+		newRepo:    "https://example.com/missing/x",
+		backend:    ExecBackend{},
+	}
+	if err := r.Clone(false); err != nil {
+		t.Fatal(err)
+	}
+	if r.Unavailable() == "" {
+		t.Errorf("missing fork is available")
+	}
+	if r.HasGitHistory() {
+		t.Errorf("missing fork has history")
+	}
+}
+
+func TestMarkUnavailable(t *testing.T) {
+	r := &Repo{oldPath: "example.com/x"}
+	missing := errors.Wrap(errOffline, "example.com/x is not cached")
+
+	if r.markUnavailable(missing) {
+		t.Errorf("marked unavailable while online")
+	}
+	setOfflineForTest(t)
+	if r.markUnavailable(errors.New("broken")) {
+		t.Errorf("marked unavailable by an unrelated error")
+	}
+	if r.Unavailable() != "" {
+		t.Errorf("unavailable: %s", r.Unavailable())
+	}
+	if !r.markUnavailable(missing) {
+		t.Errorf("not marked unavailable offline")
+	}
+	if r.Unavailable() != missing.Error() {
+		t.Errorf("unavailable: %q, want %q", r.Unavailable(), missing.Error())
+	}
+}
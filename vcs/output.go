@@ -0,0 +1,79 @@
+package vcs
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+var (
+	// outputLock keeps lines written by concurrent commands from
+	// being interleaved
+	outputLock sync.Mutex
+
+	pathLocksLock sync.Mutex
+	pathLocks     = map[string]*sync.Mutex{}
+)
+
+// lockPath serializes work on one directory across goroutines. It
+// returns the function to call to release the lock.
+func lockPath(path string) func() {
+	pathLocksLock.Lock()
+	lock, ok := pathLocks[path]
+	if !ok {
+		lock = &sync.Mutex{}
+		pathLocks[path] = lock
+	}
+	pathLocksLock.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+// prefixWriter copies output one line at a time, adding a prefix to
+// each line
+type prefixWriter struct {
+	prefix []byte
+	out    io.Writer
+	buf    []byte
+}
+
+func newPrefixWriter(prefix string, out io.Writer) *prefixWriter {
+	return &prefixWriter{
+		prefix: []byte(prefix + ": "),
+		out:    out,
+	}
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		end := bytes.IndexByte(w.buf, '\n')
+		if end < 0 {
+			break
+		}
+		err := w.writeLine(w.buf[:end+1])
+		w.buf = w.buf[end+1:]
+		if err != nil {
+			return len(p), err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush writes any partial line left in the buffer
+func (w *prefixWriter) Flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	line := append(w.buf, '\n')
+	w.buf = nil
+	return w.writeLine(line)
+}
+
+func (w *prefixWriter) writeLine(line []byte) error {
+	outputLock.Lock()
+	defer outputLock.Unlock()
+	_, err := w.out.Write(append(append([]byte{}, w.prefix...), line...))
+	return err
+}
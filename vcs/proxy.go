@@ -0,0 +1,326 @@
+package vcs
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	urlpkg "net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/mod/module"
+	modzip "golang.org/x/mod/zip"
+)
+
+// defaultProxy is used when GOPROXY is not set, as the go command does
+const defaultProxy = "https://proxy.golang.org,direct"
+
+// errNotFound is returned when a proxy does not have a module version
+var errNotFound = errors.New("not found")
+
+// Proxy is one of the module proxies from GOPROXY
+type Proxy struct {
+	// URL is the root of the proxy
+	URL string
+
+	// FallBack is set when the proxy is followed by "|", so the next
+	// one is tried after any error instead of only when the module
+	// version is not found
+	FallBack bool
+}
+
+// Proxies returns the module proxies to download from, in order, from
+// the GOPROXY environment variable. Fetching directly from the
+// repositories is left to the normal mode, so "direct" is skipped.
+// When offline, the module cache is the only proxy.
+func Proxies() ([]Proxy, error) {
+	if offline {
+		dir, err := moduleCacheDir()
+		if err != nil {
+			return nil, err
+		}
+		return []Proxy{{URL: "file://" + filepath.ToSlash(filepath.Join(dir, "cache", "download"))}}, nil
+	}
+
+	value := os.Getenv("GOPROXY")
+	if value == "" {
+		value = defaultProxy
+	}
+	proxies := parseProxies(value)
+	if len(proxies) == 0 {
+		return nil, fmt.Errorf("no module proxy in GOPROXY=%q", value)
+	}
+	return proxies, nil
+}
+
+// parseProxies returns the proxies in a GOPROXY value, along with the
+// separator that follows each of them
+func parseProxies(value string) []Proxy {
+	proxies := []Proxy{}
+	for rest := value; rest != ""; {
+		entry, sep := rest, byte(0)
+		rest = ""
+		if i := strings.IndexAny(entry, ",|"); i >= 0 {
+			entry, sep, rest = entry[:i], entry[i], entry[i+1:]
+		}
+		entry = strings.TrimSpace(entry)
+		if entry == "off" {
+			break
+		}
+		if entry == "" || entry == "direct" {
+			continue
+		}
+		proxies = append(proxies, Proxy{
+			URL:      strings.TrimSuffix(entry, "/"),
+			FallBack: sep == '|',
+		})
+	}
+	return proxies
+}
+
+// moduleCacheDir returns the module cache of the go command. Its
+// download directory is laid out like a proxy.
+func moduleCacheDir() (string, error) {
+	if dir := os.Getenv("GOMODCACHE"); dir != "" {
+		return dir, nil
+	}
+	gopath := os.Getenv("GOPATH")
+	if gopath == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", errors.Wrap(err, "could not find the module cache")
+		}
+		gopath = filepath.Join(home, "go")
+	}
+	return filepath.Join(filepath.SplitList(gopath)[0], "pkg", "mod"), nil
+}
+
+// NewProxy creates a new Repo that compares the module zips for the
+// two versions downloaded from the proxies, without cloning the
+// repositories or looking them up. When localDir is not empty it is
+// the replacement directory, compared as it is. There is no history,
+// so only the files can be compared. An alias matching the new path
+// replaces that prefix with the upstream repository to give the old
+// module to download.
+func NewProxy(workDir, oldPath, oldVersion, newPath, newVersion, localDir string, proxies []Proxy, repoAliases []Alias) (*Repo, error) {
+	repo := Repo{
+		workDir:    workDir,
+		localPath:  localClonePath(workDir, oldPath, newPath),
+		oldPath:    oldPath,
+		oldVersion: oldVersion,
+		newPath:    newPath,
+		newVersion: newVersion,
+		backend:    ExecBackend{},
+		proxies:    proxies,
+	}
+
+	if localDir != "" {
+		localDir, err := filepath.Abs(localDir)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not find absolute path of replacement directory")
+		}
+		repo.newPath = localDir
+		repo.newRepo = localDir
+		repo.localDir = localDir
+	}
+
+	for _, alias := range repoAliases {
+		if strings.HasPrefix(repo.newPath, alias.NewPrefix) {
+			repo.oldModule = alias.OldRepo + strings.TrimPrefix(repo.newPath, alias.NewPrefix)
+			repo.aliased = oldPath
+			break
+		}
+	}
+	return &repo, nil
+}
+
+// oldZipModule is the module whose zip is the old version
+func (r *Repo) oldZipModule() string {
+	if r.oldModule != "" {
+		return r.oldModule
+	}
+	return r.oldPath
+}
+
+// proxyZip returns the location of a module zip under the proxy, or
+// the cache, directory root
+func proxyZip(root, modulePath, version string) (string, error) {
+	escapedPath, err := module.EscapePath(modulePath)
+	if err != nil {
+		return "", err
+	}
+	escapedVersion, err := module.EscapeVersion(version)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/%s/@v/%s.zip", root, escapedPath, escapedVersion), nil
+}
+
+// zipCachePath is where the zip for a module version is kept. Module
+// versions never change, so it is only downloaded once.
+func (r *Repo) zipCachePath(modulePath, version string) (string, error) {
+	name, err := proxyZip("proxy", modulePath, version)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(r.workDir, "_cache", filepath.FromSlash(name)), nil
+}
+
+// download fetches the zips for both versions into the cache, trying
+// each proxy in turn
+func (r *Repo) download(verbose bool) error {
+	var err error
+	r.oldRepo, err = r.downloadOld(verbose)
+	if err != nil {
+		return err
+	}
+	if r.localDir != "" {
+		return nil
+	}
+	r.newRepo, err = r.downloadZip(verbose, r.newPath, r.newVersion)
+	return err
+}
+
+// downloadOld fetches the zip for the old version from the module the
+// alias gives, if there is one. An alias names a repository rather
+// than a module, so when no proxy has the aliased module the replaced
+// module is used instead.
+func (r *Repo) downloadOld(verbose bool) (string, error) {
+	if r.oldModule != "" {
+		url, err := r.downloadZip(verbose, r.oldModule, r.oldVersion)
+		cause := errors.Cause(err)
+		if cause != errNotFound && cause != errOffline {
+			return url, err
+		}
+		log.Printf("%s: %s, comparing with %s instead", r.oldPath, err, r.oldPath)
+		r.oldModule = ""
+		r.aliased = ""
+	}
+	return r.downloadZip(verbose, r.oldPath, r.oldVersion)
+}
+
+// downloadZip fetches the zip for one module version, unless it is
+// already in the cache, and returns the URL it came from, if known
+func (r *Repo) downloadZip(verbose bool, modulePath, version string) (string, error) {
+	if version == "" {
+		return "", fmt.Errorf("%s has no version to download", modulePath)
+	}
+	cachePath, err := r.zipCachePath(modulePath, version)
+	if err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("bad module version %s@%s", modulePath, version))
+	}
+
+	unlock := lockPath(cachePath)
+	defer unlock()
+
+	if _, err := os.Stat(cachePath); err == nil {
+		if verbose {
+			log.Printf("%s: have cache for %s@%s", r.oldPath, modulePath, version)
+		}
+		touchCache(cachePath, "", true, false)
+		return cachedURL(r.workDir, cachePath), nil
+	}
+
+	for _, proxy := range r.proxies {
+		url, err := proxyZip(proxy.URL, modulePath, version)
+		if err != nil {
+			return "", err
+		}
+
+		log.Printf("%s: downloading %s", r.oldPath, url)
+		err = fetchFile(url, cachePath)
+		if err == errNotFound {
+			if verbose {
+				log.Printf("%s: %s@%s not found in %s", r.oldPath, modulePath, version, proxy.URL)
+			}
+			continue
+		}
+		if err != nil && proxy.FallBack {
+			log.Printf("%s: could not download %s, trying the next proxy: %s", r.oldPath, url, err)
+			continue
+		}
+		if err != nil {
+			return "", errors.Wrap(err, fmt.Sprintf("could not download %s", url))
+		}
+		touchCache(cachePath, url, true, true)
+		return url, nil
+	}
+	if offline {
+		return "", errors.Wrap(errOffline, fmt.Sprintf("%s@%s is not in the module cache", modulePath, version))
+	}
+	return "", errors.Wrap(errNotFound, fmt.Sprintf("no proxy has %s@%s", modulePath, version))
+}
+
+// fetchFile copies the file at url, which may use the file scheme, to
+// dest. It returns errNotFound if there is no such file.
+func fetchFile(url, dest string) error {
+	parsed, err := urlpkg.Parse(url)
+	if err != nil {
+		return err
+	}
+
+	var body io.ReadCloser
+	if parsed.Scheme == "file" {
+		body, err = os.Open(filepath.FromSlash(parsed.Path))
+		if os.IsNotExist(err) {
+			return errNotFound
+		}
+		if err != nil {
+			return err
+		}
+	} else {
+		client := http.Client{
+			Timeout: time.Minute * 5,
+		}
+		resp, err := client.Get(url)
+		if err != nil {
+			return err
+		}
+		switch {
+		case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+			resp.Body.Close()
+			return errNotFound
+		case resp.StatusCode != http.StatusOK:
+			resp.Body.Close()
+			return fmt.Errorf("unexpected status %s", resp.Status)
+		}
+		body = resp.Body
+	}
+	defer body.Close()
+
+	err = os.MkdirAll(filepath.Dir(dest), 0755)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so an interrupted download is
+	// not mistaken for a cached zip.
+	tmp, err := ioutil.TempFile(filepath.Dir(dest), filepath.Base(dest)+".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tmp, body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), dest)
+}
+
+// unzipModule extracts the zip for a module version from the cache
+// into the new directory dest
+func (r *Repo) unzipModule(modulePath, version, dest string) error {
+	cachePath, err := r.zipCachePath(modulePath, version)
+	if err != nil {
+		return err
+	}
+	return modzip.Unzip(dest, module.Version{Path: modulePath, Version: version}, cachePath)
+}
@@ -0,0 +1,178 @@
+package vcs
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseProxies(t *testing.T) {
+	for _, tc := range []struct {
+		value string
+		want  []Proxy
+	}{
+		{"https://proxy.golang.org,direct", []Proxy{{URL: "https://proxy.golang.org"}}},
+		{"https://a/,https://b", []Proxy{{URL: "https://a"}, {URL: "https://b"}}},
+		{"https://a|https://b,https://c", []Proxy{
+			{URL: "https://a", FallBack: true},
+			{URL: "https://b"},
+			{URL: "https://c"},
+		}},
+		{"https://a, direct | https://b", []Proxy{{URL: "https://a"}, {URL: "https://b"}}},
+		{"https://a,off,https://b", []Proxy{{URL: "https://a"}}},
+		{"direct", []Proxy{}},
+		{"off", []Proxy{}},
+	} {
+		got := parseProxies(tc.value)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parseProxies(%q) = %+v, want %+v", tc.value, got, tc.want)
+		}
+	}
+}
+
+// proxyServer serves the zip of every module version, or fails every
+// request with the status if it is not zero
+func proxyServer(t *testing.T, status int) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if status != 0 {
+			w.WriteHeader(status)
+			return
+		}
+		w.Write([]byte("zip"))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestDownloadZip(t *testing.T) {
+	good := proxyServer(t, 0)
+	missing := proxyServer(t, http.StatusNotFound)
+	broken := proxyServer(t, http.StatusInternalServerError)
+
+	for _, tc := range []struct {
+		name    string
+		proxies []Proxy
+		want    string
+	}{
+		{"first", []Proxy{{URL: good.URL}, {URL: broken.URL}}, good.URL},
+		{"not found", []Proxy{{URL: missing.URL}, {URL: good.URL}}, good.URL},
+		{"error", []Proxy{{URL: broken.URL}, {URL: good.URL}}, ""},
+		{"error with fall back", []Proxy{{URL: broken.URL, FallBack: true}, {URL: good.URL}}, good.URL},
+		{"nowhere", []Proxy{{URL: missing.URL}, {URL: broken.URL, FallBack: true}}, ""},
+	} {
+		workDir, err := ioutil.TempDir("", "vcs-test-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(workDir)
+		r := &Repo{workDir: workDir, oldPath: "example.com/x", proxies: tc.proxies}
+
+		url, err := r.downloadZip(false, "example.com/x", "v1.0.0")
+		if tc.want == "" {
+			if err == nil {
+				t.Errorf("%s: downloaded %s, expected an error", tc.name, url)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			continue
+		}
+		if want := tc.want + "/example.com/x/@v/v1.0.0.zip"; url != want {
+			t.Errorf("%s: downloaded %s, want %s", tc.name, url, want)
+		}
+	}
+}
+
+func TestDownloadZipCached(t *testing.T) {
+	first := proxyServer(t, http.StatusNotFound)
+	second := proxyServer(t, 0)
+	workDir, err := ioutil.TempDir("", "vcs-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workDir)
+
+	r := &Repo{workDir: workDir, oldPath: "example.com/x", proxies: []Proxy{{URL: first.URL}, {URL: second.URL}}}
+	downloaded, err := r.downloadZip(false, "example.com/x", "v1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = SaveCacheUsage(workDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A later run, with other proxies, finds the zip in the cache
+	r = &Repo{workDir: workDir, oldPath: "example.com/x", proxies: []Proxy{{URL: "file:///nowhere"}}}
+	cached, err := r.downloadZip(false, "example.com/x", "v1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached != downloaded {
+		t.Errorf("cached zip came from %s, want %s", cached, downloaded)
+	}
+
+	// Without a record of the download the source is unknown
+	err = os.Remove(usageFile(workDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached, err = r.downloadZip(false, "example.com/x", "v1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached != "" {
+		t.Errorf("cached zip came from %s, want an unknown source", cached)
+	}
+}
+
+func TestNewProxyAlias(t *testing.T) {
+	// The proxy only has the fork and the module the alias gives.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !strings.HasPrefix(req.URL.Path, "/example.com/fork/") && !strings.HasPrefix(req.URL.Path, "/example.com/upstream/") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("zip"))
+	}))
+	t.Cleanup(server.Close)
+	proxies := []Proxy{{URL: server.URL}}
+
+	for _, tc := range []struct {
+		name        string
+		oldPath     string
+		aliases     []Alias
+		wantOld     string
+		wantAliased string
+	}{
+		{"no alias", "example.com/upstream/x", nil, "example.com/upstream/x", ""},
+		{"alias", "example.com/other/x", []Alias{{NewPrefix: "example.com/fork", OldRepo: "example.com/upstream"}}, "example.com/upstream/x", "example.com/other/x"},
+		{"alias not found", "example.com/upstream/x", []Alias{{NewPrefix: "example.com/fork", OldRepo: "example.com/missing"}}, "example.com/upstream/x", ""},
+	} {
+		workDir, err := ioutil.TempDir("", "vcs-test-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(workDir)
+
+		r, err := NewProxy(workDir, tc.oldPath, "v1.0.0", "example.com/fork/x", "v1.0.1", "", proxies, tc.aliases)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := r.download(false); err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			continue
+		}
+		if want := server.URL + "/" + tc.wantOld + "/@v/v1.0.0.zip"; r.OldRepo() != want {
+			t.Errorf("%s: old version from %s, want %s", tc.name, r.OldRepo(), want)
+		}
+		if r.Aliased() != tc.wantAliased {
+			t.Errorf("%s: aliased %q, want %q", tc.name, r.Aliased(), tc.wantAliased)
+		}
+	}
+}
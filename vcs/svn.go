@@ -0,0 +1,344 @@
+package vcs
+
+import (
+	"encoding/xml"
+	"fmt"
+	urlpkg "net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// svnTool runs Subversion. The copies are working copies without any
+// files, which remember the URL, and everything else is read from the
+// server.
+type svnTool struct{}
+
+// svnServer returns an error caused by errOffline when offline, since
+// everything but the working copies is read from the server
+func svnServer(action string) error {
+	if offline {
+		return errors.Wrap(errOffline, fmt.Sprintf("subversion needs the server to %s", action))
+	}
+	return nil
+}
+
+// svnInfo returns one item of the information about a working copy or
+// URL
+func svnInfo(target, item string, args ...string) (string, error) {
+	if !isDir(target) {
+		if err := svnServer("read " + item); err != nil {
+			return "", err
+		}
+	}
+	args = append([]string{"info", "--show-item", item}, args...)
+	args = append(args, target)
+	out, err := toolOutput("", "svn", args...)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// svnURL returns the URL of the working copy in dir
+func svnURL(dir string) (string, error) {
+	return svnInfo(dir, "url")
+}
+
+// Clone implements tool
+func (svnTool) Clone(verbose bool, prefix, url, dir string) error {
+	if err := svnServer("check out " + url); err != nil {
+		return err
+	}
+	if isDir(url) {
+		var err error
+		url, err = svnURL(url)
+		if err != nil {
+			return err
+		}
+	}
+	return runTool(verbose, prefix, filepath.Dir(dir), "svn", "checkout", "--depth", "empty", url, dir)
+}
+
+// Pull implements tool. Revision numbers are only shared by branches
+// of the same repository, so there is nothing to pull but the two
+// must be in the same repository.
+func (svnTool) Pull(verbose bool, prefix, dir, other string) error {
+	uuid, err := svnInfo(dir, "repos-uuid")
+	if err != nil {
+		return err
+	}
+	otherUUID, err := svnInfo(other, "repos-uuid")
+	if err != nil {
+		return err
+	}
+	if uuid != otherUUID {
+		return errors.New("the branches are in different repositories")
+	}
+	return nil
+}
+
+// svnRoot returns the URL of the root of the repository holding the
+// working copy in dir
+func svnRoot(dir string) (string, error) {
+	return svnInfo(dir, "repos-root-url")
+}
+
+// svnRevision identifies a revision of a path within the repository.
+// Revision numbers are shared by every branch of a repository, so the
+// path is needed to tell which line of history is meant.
+type svnRevision struct {
+	path string
+	rev  int
+}
+
+func (r svnRevision) String() string {
+	return fmt.Sprintf("%s@%d", r.path, r.rev)
+}
+
+// url returns the URL of the path at the revision, with sub added to
+// the path when it is not empty
+func (r svnRevision) url(root, sub string) string {
+	path := r.path
+	if sub != "" {
+		path = fmt.Sprintf("%s/%s", path, sub)
+	}
+	return fmt.Sprintf("%s%s@%d", root, (&urlpkg.URL{Path: path}).EscapedPath(), r.rev)
+}
+
+// parseSvnRevision reads an identifier returned by Revision
+func parseSvnRevision(id string) (svnRevision, error) {
+	i := strings.LastIndex(id, "@")
+	if i < 0 {
+		return svnRevision{}, fmt.Errorf("bad revision %q", id)
+	}
+	rev, err := strconv.Atoi(id[i+1:])
+	if err != nil {
+		return svnRevision{}, errors.Wrap(err, fmt.Sprintf("bad revision %q", id))
+	}
+	return svnRevision{path: id[:i], rev: rev}, nil
+}
+
+// Revision implements tool. Subversion has no tags as far as modules
+// are concerned, so only the revision numbers in pseudo-versions can
+// be found. The identifiers include the path of the working copy
+// within the repository.
+func (svnTool) Revision(dir string, candidates []string) (string, error) {
+	url, err := svnURL(dir)
+	if err != nil {
+		return "", err
+	}
+	relative, err := svnInfo(dir, "relative-url")
+	if err != nil {
+		return "", err
+	}
+	// The log shows paths without the escaping used in URLs.
+	path, err := urlpkg.PathUnescape(strings.TrimPrefix(relative, "^"))
+	if err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("bad path %q", relative))
+	}
+
+	if len(candidates) == 0 {
+		rev, err := svnInfo(url, "last-changed-revision", "--revision", "HEAD")
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s@%s", path, rev), nil
+	}
+	for _, candidate := range candidates {
+		if strings.Trim(candidate, "0123456789") != "" {
+			continue
+		}
+		rev := strings.TrimLeft(candidate, "0")
+		if _, err := svnInfo(url, "revision", "--revision", rev); err == nil {
+			return fmt.Sprintf("%s@%s", path, rev), nil
+		}
+	}
+	return "", fmt.Errorf("no revision %s", candidates[0])
+}
+
+// svnLog is the XML output of svn log
+type svnLog struct {
+	Entries []struct {
+		Revision string    `xml:"revision,attr"`
+		Author   string    `xml:"author"`
+		Date     time.Time `xml:"date"`
+		Message  string    `xml:"msg"`
+		Paths    []struct {
+			Path         string `xml:",chardata"`
+			CopyFromPath string `xml:"copyfrom-path,attr"`
+			CopyFromRev  string `xml:"copyfrom-rev,attr"`
+		} `xml:"paths>path"`
+	} `xml:"logentry"`
+}
+
+// readSvnLog runs svn log with XML output and parses it
+func readSvnLog(args ...string) (*svnLog, error) {
+	if err := svnServer("read the log"); err != nil {
+		return nil, err
+	}
+	out, err := toolOutput("", "svn", append([]string{"log", "--xml"}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	var entries svnLog
+	err = xml.Unmarshal([]byte(out), &entries)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse log")
+	}
+	return &entries, nil
+}
+
+// copySource finds where the branch at r was copied from. It returns
+// the revision that was copied and the revision making the copy, or
+// ok false if the history of the path does not start with a copy.
+func copySource(root string, r svnRevision) (from svnRevision, copyRev int, ok bool, err error) {
+	// Stopping on the copy and listing the oldest revision first
+	// gives the one that created the branch.
+	entries, err := readSvnLog("--verbose", "--stop-on-copy", "--limit", "1",
+		"--revision", fmt.Sprintf("1:%d", r.rev), r.url(root, ""))
+	if err != nil {
+		return svnRevision{}, 0, false, errors.Wrap(err, fmt.Sprintf("could not find where %s was copied from", r))
+	}
+	for _, entry := range entries.Entries {
+		for _, p := range entry.Paths {
+			if p.Path != r.path || p.CopyFromPath == "" {
+				continue
+			}
+			fromRev, err := strconv.Atoi(p.CopyFromRev)
+			if err != nil {
+				return svnRevision{}, 0, false, errors.Wrap(err, fmt.Sprintf("bad copy revision for %s", r.path))
+			}
+			copyRev, err = strconv.Atoi(entry.Revision)
+			if err != nil {
+				return svnRevision{}, 0, false, errors.Wrap(err, fmt.Sprintf("bad revision for %s", r.path))
+			}
+			return svnRevision{path: p.CopyFromPath, rev: fromRev}, copyRev, true, nil
+		}
+	}
+	return svnRevision{}, 0, false, nil
+}
+
+// mergeBase finds the common ancestor of two revisions, along with the
+// revision that created the branch of b when b is on a branch copied
+// from the path of a, or zero
+func (svnTool) mergeBase(root string, a, b svnRevision) (svnRevision, int, error) {
+	older := func(x svnRevision, rev int) svnRevision {
+		if rev < x.rev {
+			return svnRevision{path: x.path, rev: rev}
+		}
+		return x
+	}
+
+	// The history of one path is a line, so the older revision is
+	// where the two meet.
+	if a.path == b.path {
+		return older(a, b.rev), 0, nil
+	}
+
+	// A branch copied from the other path meets it at the copy.
+	for _, pair := range []struct{ branch, other svnRevision }{{b, a}, {a, b}} {
+		from, copyRev, ok, err := copySource(root, pair.branch)
+		if err != nil {
+			return svnRevision{}, 0, err
+		}
+		if ok && from.path == pair.other.path {
+			base := older(pair.other, from.rev)
+			if pair.branch == b {
+				return base, copyRev, nil
+			}
+			return base, 0, nil
+		}
+	}
+	return svnRevision{}, 0, fmt.Errorf("%s was not copied from %s or the other way around", b.path, a.path)
+}
+
+// MergeBase implements tool. Branches copied from the other path meet
+// it at the copy, and other branches have no common ancestor that can
+// be found.
+func (t svnTool) MergeBase(dir, a, b string) (string, error) {
+	revA, err := parseSvnRevision(a)
+	if err != nil {
+		return "", err
+	}
+	revB, err := parseSvnRevision(b)
+	if err != nil {
+		return "", err
+	}
+	root, err := svnRoot(dir)
+	if err != nil {
+		return "", err
+	}
+	base, _, err := t.mergeBase(root, revA, revB)
+	if err != nil {
+		return "", err
+	}
+	return base.String(), nil
+}
+
+// Log implements tool. The commits are the ones on the path of to
+// after the common ancestor, or after the copy that created its
+// branch, which is not a change of its own.
+func (t svnTool) Log(dir, from, to, path string) ([]Commit, error) {
+	revFrom, err := parseSvnRevision(from)
+	if err != nil {
+		return nil, err
+	}
+	revTo, err := parseSvnRevision(to)
+	if err != nil {
+		return nil, err
+	}
+	root, err := svnRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	base, copyRev, err := t.mergeBase(root, revFrom, revTo)
+	if err != nil {
+		return nil, err
+	}
+	start := base.rev + 1
+	if copyRev >= start {
+		start = copyRev + 1
+	}
+	if revTo.rev < start {
+		return []Commit{}, nil
+	}
+
+	entries, err := readSvnLog("--stop-on-copy",
+		"--revision", fmt.Sprintf("%d:%d", revTo.rev, start),
+		revTo.url(root, path))
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("could not read log of %s..%s", from, to))
+	}
+
+	commits := []Commit{}
+	for _, entry := range entries.Entries {
+		commits = append(commits, Commit{
+			Hash:    entry.Revision,
+			Date:    entry.Date,
+			Author:  entry.Author,
+			Subject: strings.SplitN(strings.TrimSpace(entry.Message), "\n", 2)[0],
+		})
+	}
+	return commits, nil
+}
+
+// Export implements tool
+func (svnTool) Export(dir, rev, dest string) error {
+	if err := svnServer("export " + rev); err != nil {
+		return err
+	}
+	r, err := parseSvnRevision(rev)
+	if err != nil {
+		return err
+	}
+	root, err := svnRoot(dir)
+	if err != nil {
+		return err
+	}
+	return runTool(false, "", "", "svn", "export", "--quiet",
+		"--revision", strconv.Itoa(r.rev), r.url(root, ""), dest)
+}
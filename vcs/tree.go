@@ -0,0 +1,301 @@
+package vcs
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-billy/v5/osfs"
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/pkg/errors"
+)
+
+// gitTool is the tool for a git repository compared with one using a
+// different system, so only its trees are needed
+type gitTool struct {
+	backend Backend
+}
+
+// Clone implements tool
+func (t gitTool) Clone(verbose bool, prefix, url, dir string) error {
+	return t.backend.Clone(verbose, prefix, url, dir)
+}
+
+// Pull implements tool
+func (t gitTool) Pull(verbose bool, prefix, dir, other string) error {
+	return errors.New("git histories are combined by Repo.Clone")
+}
+
+// Revision implements tool
+func (t gitTool) Revision(dir string, candidates []string) (string, error) {
+	if len(candidates) == 0 {
+		return t.backend.RevParse(dir, "HEAD")
+	}
+	for _, candidate := range candidates {
+		for _, rev := range []string{"refs/tags/" + candidate, candidate} {
+			if hash, err := t.backend.RevParse(dir, rev); err == nil {
+				return hash, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no revision %s", candidates[0])
+}
+
+// MergeBase implements tool
+func (t gitTool) MergeBase(dir, a, b string) (string, error) {
+	return t.backend.MergeBase(dir, a, b)
+}
+
+// Log implements tool
+func (t gitTool) Log(dir, from, to, path string) ([]Commit, error) {
+	return t.backend.Log(dir, from, to, path)
+}
+
+// Export implements tool. The files are read with go-git so git
+// archive and tar are not needed.
+func (t gitTool) Export(dir, rev, dest string) error {
+	repo, err := gogit.PlainOpen(dir)
+	if err != nil {
+		return err
+	}
+	commit, err := resolveCommit(repo, rev)
+	if err != nil {
+		return err
+	}
+	files, err := commit.Files()
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("could not read tree of %s", rev))
+	}
+	return files.ForEach(func(f *object.File) error {
+		return writeFile(dest, f)
+	})
+}
+
+// writeFile copies a file from a git tree into the directory dest
+func writeFile(dest string, f *object.File) error {
+	filename := filepath.Join(dest, filepath.FromSlash(f.Name))
+	err := os.MkdirAll(filepath.Dir(filename), 0755)
+	if err != nil {
+		return err
+	}
+
+	contents, err := f.Reader()
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("could not read %s", f.Name))
+	}
+	defer contents.Close()
+
+	if f.Mode == filemode.Symlink {
+		target, err := ioutil.ReadAll(contents)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("could not read %s", f.Name))
+		}
+		return os.Symlink(string(target), filename)
+	}
+
+	perm := os.FileMode(0644)
+	if f.Mode == filemode.Executable {
+		perm = 0755
+	}
+	out, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, contents)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// extractZip writes the files in the archive with names starting with
+// prefix to the directory dest, without the prefix
+func extractZip(filename, prefix, dest string) error {
+	archive, err := zip.OpenReader(filename)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("could not open %s", filename))
+	}
+	defer archive.Close()
+
+	root := filepath.Clean(dest) + string(os.PathSeparator)
+	for _, f := range archive.File {
+		if !strings.HasPrefix(f.Name, prefix) || strings.HasSuffix(f.Name, "/") {
+			continue
+		}
+		target := filepath.Join(dest, filepath.FromSlash(strings.TrimPrefix(f.Name, prefix)))
+		if !strings.HasPrefix(target, root) {
+			return fmt.Errorf("%s in %s is outside of the archive", f.Name, filename)
+		}
+		err := extractFile(f, target)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("could not extract %s from %s", f.Name, filename))
+		}
+	}
+	return nil
+}
+
+func extractFile(f *zip.File, target string) error {
+	err := os.MkdirAll(filepath.Dir(target), 0755)
+	if err != nil {
+		return err
+	}
+	contents, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer contents.Close()
+
+	perm := os.FileMode(0644)
+	if f.Mode()&0111 != 0 {
+		perm = 0755
+	}
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, contents)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// oldTreeSubdir is the directory of the module within the upstream
+// tree. When the upstream repository comes from an alias the module is
+// in the same directory as in the fork.
+func (r *Repo) oldTreeSubdir() string {
+	if r.aliased != "" {
+		return r.path()
+	}
+	return r.oldSubdir
+}
+
+// treeDiff compares the files of the two versions without using their
+// history, by exporting each version and committing it to a scratch
+// git repository to be diffed. The result includes the patches, and is
+// remembered because the exports can be slow.
+func (r *Repo) treeDiff() ([]FileStat, error) {
+	if r.treeStats != nil {
+		return r.treeStats, nil
+	}
+
+	scratch, err := ioutil.TempDir("", "go-fork-diff-trees-")
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create directory for trees")
+	}
+	defer os.RemoveAll(scratch)
+
+	oldTree, err := r.exportOld(filepath.Join(scratch, "old"))
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("could not export %s from %s", r.oldVersion, r.oldRepo))
+	}
+	newTree, err := r.exportNew(filepath.Join(scratch, "new"))
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("could not export %s from %s", r.newVersion, r.newRepo))
+	}
+
+	gitDir := filepath.Join(scratch, "git")
+	_, err = gogit.PlainInit(gitDir, true)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create repository for trees")
+	}
+	hashes := []string{}
+	for _, tree := range []string{oldTree, newTree} {
+		hash, err := commitTree(gitDir, tree)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("could not record tree %s", tree))
+		}
+		hashes = append(hashes, hash.String())
+	}
+
+	// The trees are already limited to the module directory.
+	pathspec := []string{"."}
+	if r.path() == "" {
+		pathspec = append(pathspec, ":!vendor")
+	}
+	stats, err := GoGitBackend{}.Diff(gitDir, hashes[0], hashes[1], pathspec, true)
+	if err != nil {
+		return nil, err
+	}
+	r.treeStats = stats
+	return stats, nil
+}
+
+// exportOld writes the files of the old version to dest and returns
+// the directory of the module within it
+func (r *Repo) exportOld(dest string) (string, error) {
+	if r.proxies != nil {
+		return dest, r.unzipModule(r.oldZipModule(), r.oldVersion, dest)
+	}
+	upstream, err := newTool(r.oldVCS, r.backend)
+	if err != nil {
+		return "", err
+	}
+	err = upstream.Export(r.cachePath(r.oldRepo), r.oldRev, dest)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dest, filepath.FromSlash(r.oldTreeSubdir())), nil
+}
+
+// exportNew writes the files of the new version to dest and returns
+// the directory of the module within it. A local replacement directory
+// is used as it is.
+func (r *Repo) exportNew(dest string) (string, error) {
+	if r.localDir != "" {
+		return r.localDir, nil
+	}
+	if r.proxies != nil {
+		return dest, r.unzipModule(r.newPath, r.newVersion, dest)
+	}
+	fork, err := newTool(r.newVCS, r.backend)
+	if err != nil {
+		return "", err
+	}
+	err = fork.Export(r.forkRepoDir(), r.newRev, dest)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dest, filepath.FromSlash(r.path())), nil
+}
+
+// commitTree records the files in the directory tree as a new commit
+// in the bare repository gitDir, removing any files from the previous
+// commit that are not in the directory
+func commitTree(gitDir, tree string) (plumbing.Hash, error) {
+	err := os.MkdirAll(tree, 0755)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	storage := filesystem.NewStorage(osfs.New(gitDir), cache.NewObjectLRUDefault())
+	repo, err := gogit.Open(storage, osfs.New(tree))
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	err = worktree.AddWithOptions(&gogit.AddOptions{All: true})
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return worktree.Commit(tree, &gogit.CommitOptions{
+		All:               true,
+		AllowEmptyCommits: true,
+		Author: &object.Signature{
+			Name: "go-fork-diff",
+			When: time.Now(),
+		},
+	})
+}
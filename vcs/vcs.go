@@ -1,16 +1,23 @@
 package vcs
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"fmt"
+	"io"
 	"log"
 	urlpkg "net/url"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/dhellmann/go-fork-diff/discovery"
 	"github.com/pkg/errors"
+	"golang.org/x/mod/module"
 )
 
 const remoteName = "replace"
@@ -24,37 +31,64 @@ type Alias struct {
 func New(workDir, oldPath, oldVersion, newPath, newVersion string, repoAliases []Alias) (*Repo, error) {
 	repo := Repo{
 		workDir:    workDir,
-		localPath:  filepath.Join(workDir, oldPath),
+		localPath:  localClonePath(workDir, oldPath, newPath),
 		oldPath:    oldPath,
 		oldVersion: oldVersion,
 		newPath:    newPath,
 		newVersion: newVersion,
+		backend:    ExecBackend{},
 	}
 
+	err := repo.resolveOld(repoAliases)
+	if repo.markUnavailable(err) {
+		return &repo, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	newRoot, err := resolveOne(newPath)
+	if err != nil {
+		err = errors.Wrap(err, "could not resolve new repository from module path")
+		if repo.markUnavailable(err) {
+			return &repo, nil
+		}
+		return nil, err
+	}
+	repo.newRepo = newRoot.Root
+	repo.newSource = newRoot.Source
+	repo.newVCS = newRoot.VCS
+	repo.newSubdir, repo.newMajorSubdir = moduleSubdir(newPath, newRoot.Prefix)
+
+	return &repo, nil
+}
+
+// resolveOld finds the upstream repository, taking the aliases into
+// account
+func (r *Repo) resolveOld(repoAliases []Alias) error {
+	oldPath := r.oldPath
 	for _, alias := range repoAliases {
-		if strings.HasPrefix(newPath, alias.NewPrefix) {
+		if strings.HasPrefix(r.newPath, alias.NewPrefix) {
 			oldPath = alias.OldRepo
-			repo.aliased, _ = resolveOne(repo.oldPath)
-			if repo.aliased == "" {
-				repo.aliased = repo.oldPath
+			r.aliased = r.oldPath
+			if aliasedRoot, err := resolveOne(r.oldPath); err == nil {
+				r.aliased = aliasedRoot.Root
 			}
 			break
 		}
 	}
 
-	oldRepo, err := resolveOne(oldPath)
+	oldRoot, err := resolveOne(oldPath)
 	if err != nil {
-		return nil, errors.Wrap(err, "could not resolve old repository from module path")
+		return errors.Wrap(err, "could not resolve old repository from module path")
 	}
-	repo.oldRepo = oldRepo
-
-	newRepo, err := resolveOne(newPath)
-	if err != nil {
-		return nil, errors.Wrap(err, "could not resolve new repository from module path")
+	r.oldRepo = oldRoot.Root
+	r.oldSource = oldRoot.Source
+	r.oldVCS = oldRoot.VCS
+	if r.aliased == "" {
+		r.oldSubdir, _ = moduleSubdir(r.oldPath, oldRoot.Prefix)
 	}
-	repo.newRepo = newRepo
-
-	return &repo, nil
+	return nil
 }
 
 // Repo holds all of the information about one dependency
@@ -85,6 +119,150 @@ type Repo struct {
 
 	// aliased holds the oldPath value that was replaced by the alias
 	aliased string
+
+	// oldModule is the module the zip of the old version is
+	// downloaded for, when an alias gives one other than oldPath
+	oldModule string
+
+	// oldVersionSource explains where oldVersion came from
+	oldVersionSource string
+
+	// localDir is set when the replacement is a directory on the
+	// local filesystem instead of a module, and localSubdir is the
+	// location of that directory within its repository
+	localDir    string
+	localSubdir string
+
+	// oldSubdir and newSubdir are the directories of the modules
+	// within their repositories, without any major version suffix.
+	// newMajorSubdir includes the suffix, and is where the module is
+	// if the fork uses major version subdirectories.
+	oldSubdir      string
+	newSubdir      string
+	newMajorSubdir string
+
+	// snapshot is the commit recording the state of the working tree
+	// of localDir
+	snapshot string
+
+	// oldSource and newSource hold the go-source settings for
+	// browsing the repositories, when discovery found them
+	oldSource *discovery.Source
+	newSource *discovery.Source
+
+	// oldBranch and newBranch are the default branches of the
+	// remotes, found when cloning
+	oldBranch string
+	newBranch string
+
+	// forkBranch is the branch of the fork to compare when the new
+	// version does not name a commit, overriding newBranch
+	forkBranch string
+
+	// backend runs the version control operations
+	backend Backend
+
+	// proxies are the module proxies to download zips of the two
+	// versions from, instead of cloning the repositories
+	proxies []Proxy
+
+	// oldVCS and newVCS are the version control systems of the
+	// repositories, as given by discovery
+	oldVCS string
+	newVCS string
+
+	// oldRev, newRev and mergeBase are the revisions found by Clone
+	// when either repository does not use git, and treeStats holds
+	// the result of comparing their trees
+	oldRev    string
+	newRev    string
+	mergeBase string
+	treeStats []FileStat
+
+	// unavailable is the reason the repository cannot be compared,
+	// when something it needs is missing while offline
+	unavailable error
+}
+
+// OldPath returns the module path being replaced
+func (r *Repo) OldPath() string {
+	return r.oldPath
+}
+
+// OldVersion returns the upstream version of the module
+func (r *Repo) OldVersion() string {
+	return r.oldVersion
+}
+
+// OldVersionSource explains where the upstream version came from
+func (r *Repo) OldVersionSource() string {
+	return r.oldVersionSource
+}
+
+// OldRepo returns the URL of the upstream repository
+func (r *Repo) OldRepo() string {
+	return r.oldRepo
+}
+
+// NewPath returns the module path of the replacement
+func (r *Repo) NewPath() string {
+	return r.newPath
+}
+
+// NewVersion returns the version of the replacement module
+func (r *Repo) NewVersion() string {
+	return r.newVersion
+}
+
+// NewRepo returns the URL of the fork repository
+func (r *Repo) NewRepo() string {
+	return r.newRepo
+}
+
+// OldSource returns the go-source settings for the upstream
+// repository, or nil
+func (r *Repo) OldSource() *discovery.Source {
+	return r.oldSource
+}
+
+// OldVCS returns the version control system of the upstream repository
+func (r *Repo) OldVCS() string {
+	return vcsName(r.oldVCS)
+}
+
+// NewVCS returns the version control system of the fork repository
+func (r *Repo) NewVCS() string {
+	return vcsName(r.newVCS)
+}
+
+// NewSource returns the go-source settings for the fork repository,
+// or nil
+func (r *Repo) NewSource() *discovery.Source {
+	return r.newSource
+}
+
+// Aliased returns the repository the old path would have resolved to
+// without an alias, or an empty string if no alias was used
+func (r *Repo) Aliased() string {
+	return r.aliased
+}
+
+// SetOldVersion changes the upstream version, recording where the
+// value came from so the report can explain it
+func (r *Repo) SetOldVersion(version, source string) {
+	r.oldVersion = version
+	r.oldVersionSource = source
+}
+
+// SetBackend changes how the version control operations are run
+func (r *Repo) SetBackend(backend Backend) {
+	r.backend = backend
+}
+
+// SetForkBranch chooses the branch of the fork to compare when the new
+// version does not name a commit
+func (r *Repo) SetForkBranch(branch string) {
+	r.forkBranch = branch
 }
 
 func (r *Repo) String() string {
@@ -96,10 +274,30 @@ func (r *Repo) String() string {
 	if r.aliased != "" {
 		s = fmt.Sprintf("%s\n  aliased: %s", s, r.aliased)
 	}
+	if r.oldVersionSource != "" {
+		s = fmt.Sprintf("%s\n  version from: %s", s, r.oldVersionSource)
+	}
+	if r.forkBranch != "" {
+		s = fmt.Sprintf("%s\n  fork branch: %s", s, r.forkBranch)
+	}
+	if r.foreign() {
+		s = fmt.Sprintf("%s\n  vcs: %s upstream, %s fork", s, r.OldVCS(), r.NewVCS())
+	}
+	if r.unavailable != nil {
+		s = fmt.Sprintf("%s\n  unavailable: %s", s, r.unavailable)
+	}
 	return s
 }
 
 func git(verbose bool, directory string, args ...string) error {
+	return runGit(verbose, "", directory, args...)
+}
+
+// runGit runs git in directory. When verbose, the command and its
+// output are shown, with each line labeled with the prefix if one is
+// given. The output goes to stderr, like the progress messages, so it
+// never mixes with a report written to stdout.
+func runGit(verbose bool, prefix string, directory string, args ...string) error {
 	cmdArgs := []string{"--no-pager", "-C", directory}
 	cmdArgs = append(cmdArgs, args...)
 	if verbose {
@@ -110,23 +308,84 @@ func git(verbose bool, directory string, args ...string) error {
 			}
 			printableArgs = append(printableArgs, a)
 		}
-		log.Printf("git %s\n\n", strings.Join(printableArgs, " "))
+		if prefix != "" {
+			log.Printf("%s: git %s\n\n", prefix, strings.Join(printableArgs, " "))
+		} else {
+			log.Printf("git %s\n\n", strings.Join(printableArgs, " "))
+		}
 	}
 	cmd := exec.Command("git", cmdArgs...)
 	if verbose {
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
+		if prefix != "" {
+			stdout := newPrefixWriter(prefix, os.Stderr)
+			defer stdout.Flush()
+			stderr := newPrefixWriter(prefix, os.Stderr)
+			defer stderr.Flush()
+			cmd.Stdout = stdout
+			cmd.Stderr = stderr
+		} else {
+			cmd.Stdout = os.Stderr
+			cmd.Stderr = os.Stderr
+		}
 	}
 	return cmd.Run()
 }
 
-func cloneToCache(verbose bool, cachePath string, repoURL string) error {
+func gitOutput(directory string, args ...string) (string, error) {
+	return gitOutputEnv(nil, directory, args...)
+}
+
+// gitOutputEnv runs git with extra environment variables and returns
+// its output
+func gitOutputEnv(env []string, directory string, args ...string) (string, error) {
+	return gitFilter(env, "", directory, args...)
+}
+
+// gitFilter runs git with extra environment variables, feeding it
+// input on stdin if there is any, and returns its output
+func gitFilter(env []string, input string, directory string, args ...string) (string, error) {
+	cmdArgs := []string{"--no-pager", "-C", directory}
+	cmdArgs = append(cmdArgs, args...)
+	cmd := exec.Command("git", cmdArgs...)
+	if env != nil {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	if input != "" {
+		cmd.Stdin = strings.NewReader(input)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		subcommand := args[0]
+		if subcommand == "-c" && len(args) > 2 {
+			subcommand = args[2]
+		}
+		return "", errors.Wrap(err, fmt.Sprintf("git %s failed: %s",
+			subcommand, strings.TrimSpace(stderr.String())))
+	}
+	return string(out), nil
+}
+
+// cloner copies repositories, and is satisfied by both Backend and
+// the tools for other version control systems
+type cloner interface {
+	Clone(verbose bool, prefix, url, dir string) error
+}
+
+func cloneToCache(backend cloner, verbose bool, prefix string, cachePath string, repoURL string) error {
+	// Several modules may live in the same repository, so make sure
+	// only one of them populates the cache.
+	unlock := lockPath(cachePath)
+	defer unlock()
+
 	_, err := os.Stat(cachePath)
 	if err == nil {
 		// cache exists
 		if verbose {
-			log.Printf("have cache for %s", repoURL)
+			log.Printf("%s: have cache for %s", prefix, repoURL)
 		}
+		touchCache(cachePath, repoURL, true, false)
 		return nil
 	}
 
@@ -135,23 +394,73 @@ func cloneToCache(verbose bool, cachePath string, repoURL string) error {
 		return errors.Wrap(err, "error checking cache")
 	}
 
+	if offline {
+		return errOffline
+	}
+
 	cacheParentDir := filepath.Dir(cachePath)
 	err = os.MkdirAll(cacheParentDir, 0755)
 	if err != nil {
 		return errors.Wrap(err, "failed to create cache directory for cache")
 	}
 
-	log.Printf("caching %s in %s", repoURL, cachePath)
-	err = git(verbose, cacheParentDir, "clone", repoURL)
+	log.Printf("%s: caching %s in %s", prefix, repoURL, cachePath)
+	err = backend.Clone(verbose, prefix, repoURL, cachePath)
 	if err != nil {
 		return errors.Wrap(err, fmt.Sprintf("failed to clone %s", repoURL))
 	}
+	touchCache(cachePath, repoURL, true, true)
 	return nil
 }
 
+// localClonePath returns the directory of the local clone comparing
+// the fork at newPath with the module at oldPath. A module may be
+// replaced by different forks in different go.mod files, and the clone
+// only has one remote for the fork, so each fork gets its own clone.
+func localClonePath(workDir, oldPath, newPath string) string {
+	sum := sha256.Sum256([]byte(newPath))
+	return filepath.Join(workDir, fmt.Sprintf("%s@%x", oldPath, sum[:4]))
+}
+
+func (r *Repo) cachePath(repoURL string) string {
+	// Drop the scheme, which is not always https for other version
+	// control systems.
+	if i := strings.Index(repoURL, "://"); i >= 0 {
+		repoURL = repoURL[i+3:]
+	}
+	return filepath.Join(r.workDir, "_cache", repoURL)
+}
+
+// forkRepoDir returns the local repository holding the fork
+func (r *Repo) forkRepoDir() string {
+	if r.localDir != "" {
+		return r.newRepo
+	}
+	return r.cachePath(r.newRepo)
+}
+
 // Clone configures the local copy of the repository with the relevant
-// remotes
+// remotes. When offline, a repository missing from the cache marks the
+// Repo unavailable instead of failing.
 func (r *Repo) Clone(verbose bool) error {
+	if r.unavailable != nil {
+		return nil
+	}
+	err := r.clone(verbose)
+	if r.markUnavailable(err) {
+		return nil
+	}
+	return err
+}
+
+func (r *Repo) clone(verbose bool) error {
+	if r.proxies != nil {
+		return r.download(verbose)
+	}
+	if r.foreign() {
+		return r.cloneForeign(verbose)
+	}
+
 	parentDir := filepath.Dir(r.localPath)
 
 	err := os.MkdirAll(parentDir, 0755)
@@ -159,21 +468,28 @@ func (r *Repo) Clone(verbose bool) error {
 		return errors.Wrap(err, "failed to create output directory for clone")
 	}
 
-	oldCachePath := filepath.Join(r.workDir, "_cache", r.oldRepo[8:])
-	err = cloneToCache(verbose, oldCachePath, r.oldRepo)
+	oldCachePath := r.cachePath(r.oldRepo)
+	err = cloneToCache(r.backend, verbose, r.oldPath, oldCachePath, r.oldRepo)
 	if err != nil {
 		return errors.Wrap(err, fmt.Sprintf("failed to create cache of %s", r.oldRepo))
 	}
 
-	newCachePath := filepath.Join(r.workDir, "_cache", r.newRepo[8:])
-	err = cloneToCache(verbose, newCachePath, r.newRepo)
-	if err != nil {
-		return errors.Wrap(err, fmt.Sprintf("failed to create cache of %s", r.newRepo))
+	// A fork in a local directory is used in place, so it always
+	// reflects the current state of the working tree.
+	newCachePath := r.forkRepoDir()
+	if r.localDir == "" {
+		err = cloneToCache(r.backend, verbose, r.oldPath, newCachePath, r.newRepo)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("failed to create cache of %s", r.newRepo))
+		}
 	}
 
+	unlock := lockPath(r.localPath)
+	defer unlock()
+
 	if _, err := os.Stat(r.localPath); os.IsNotExist(err) {
 		log.Printf("%s: cloning %s", r.oldPath, r.oldRepo)
-		err := git(verbose, parentDir, "clone", oldCachePath, filepath.Base(r.localPath))
+		err := r.backend.Clone(verbose, r.oldPath, oldCachePath, r.localPath)
 		if err != nil {
 			return errors.Wrap(err, fmt.Sprintf("failed to clone %s", r.oldRepo))
 		}
@@ -183,69 +499,191 @@ func (r *Repo) Clone(verbose bool) error {
 		}
 	}
 
-	err = r.git(false, "remote", "get-url", remoteName)
+	remoteURL, err := r.backend.RemoteURL(r.localPath, remoteName)
 	if err != nil {
+		return errors.Wrap(err, "could not read fork remote")
+	}
+	switch {
+	case remoteURL == "":
 		log.Printf("%s: adding fork remote for %s", r.oldPath, r.newRepo)
-		err = r.git(verbose, "remote", "add", remoteName, newCachePath)
+		err = r.backend.AddRemote(verbose, r.oldPath, r.localPath, remoteName, newCachePath)
 		if err != nil {
 			return errors.Wrap(err, fmt.Sprintf("could not add remote %s", r.newRepo))
 		}
-
-		err = r.git(verbose, "fetch", "--all", "--tags")
+	case remoteURL != newCachePath:
+		// The module has been replaced by a different fork since
+		// the last run.
+		log.Printf("%s: changing fork remote to %s", r.oldPath, r.newRepo)
+		err = r.backend.SetRemoteURL(verbose, r.oldPath, r.localPath, remoteName, newCachePath)
 		if err != nil {
-			return errors.Wrap(err, fmt.Sprintf("could not update remote %s", r.newRepo))
+			return errors.Wrap(err, fmt.Sprintf("could not change remote to %s", r.newRepo))
 		}
-	} else {
+	default:
 		if verbose {
 			log.Printf("%s: remote: %s", r.oldPath, r.newRepo)
 		}
 	}
 
+	// The caches may have been refreshed since the clone was made, and
+	// versions are resolved in the caches, so bring the clone up to
+	// date with both of them every time. They are local, so this is
+	// cheap.
+	for _, remote := range []struct{ name, url string }{
+		{"origin", r.oldRepo},
+		{remoteName, r.newRepo},
+	} {
+		err = r.backend.Fetch(verbose, r.oldPath, r.localPath, remote.name)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("could not update remote %s", remote.url))
+		}
+	}
+
+	r.oldBranch = r.defaultBranch(verbose, "origin")
+
+	if r.localDir != "" {
+		return r.snapshotWorkTree(verbose)
+	}
+
+	r.newBranch = r.defaultBranch(verbose, remoteName)
+	r.findMajorSubdir()
 	return nil
 }
 
-func refFromVersion(version string) string {
-	if version == "" || version == "v0.0.0" {
-		return ""
+// findMajorSubdir switches to the major version subdirectory of the
+// fork if the new version has a go.mod file there
+func (r *Repo) findMajorSubdir() {
+	if r.newMajorSubdir == r.newSubdir {
+		return
 	}
+	_, newRef := r.gitRefs()
+	found, err := r.backend.HasFile(r.localPath, newRef, path.Join(r.newMajorSubdir, "go.mod"))
+	if err == nil && found {
+		r.newSubdir = r.newMajorSubdir
+	}
+}
 
-	result := version
-
-	// if the version look like
-	// v11.0.1-0.20190409021438-1a26190bd76a+incompatible start with
-	// the 3rd part
-	parts := strings.Split(result, "-")
-	if len(parts) >= 3 {
-		result = parts[len(parts)-1]
+// moduleSubdir returns the directory of the module within the
+// repository with the root import path prefix, first without and then
+// with the major version suffix of the module path
+func moduleSubdir(modulePath, prefix string) (string, string) {
+	if prefix == "" || modulePath == prefix || !strings.HasPrefix(modulePath, prefix+"/") {
+		return "", ""
 	}
+	dir := strings.TrimPrefix(modulePath, prefix+"/")
+	return stripMajorVersion(dir), dir
+}
 
-	// if the version looks like 1a26190bd76a+incompatible take the
-	// first part
-	parts = strings.Split(result, "+")
-	if len(parts) > 1 {
-		result = parts[0]
+// stripMajorVersion removes the major version suffix from a directory
+// within a repository
+func stripMajorVersion(dir string) string {
+	// The leading slash lets a directory that is only the major
+	// version, such as "v2", be recognized.
+	withoutMajor, _, ok := module.SplitPathVersion("/" + dir)
+	if !ok {
+		return dir
 	}
+	return strings.TrimPrefix(withoutMajor, "/")
+}
 
-	// if the version is now all zeros, return empty string
-	if strings.Trim(result, "0") == "" {
-		result = ""
+// defaultBranch returns the remote tracking branch for the default
+// branch of the remote, as given by its HEAD, or an empty string if
+// that cannot be determined
+func (r *Repo) defaultBranch(verbose bool, remote string) string {
+	branch, err := r.backend.DefaultBranch(verbose, r.oldPath, r.localPath, remote)
+	if err != nil {
+		log.Printf("%s: could not find the default branch of %s", r.oldPath, remote)
+		return ""
 	}
+	return branch
+}
 
-	return result
+// ErrNoTagMessage is the cause of the errors from ForkTagMessage when
+// the fork version has no annotated tag, as opposed to when the tag
+// could not be read
+var ErrNoTagMessage = errors.New("no annotated tag")
+
+// ForkTagMessage returns the message of the annotated tag for the new
+// version in the cached copy of the fork repository
+func (r *Repo) ForkTagMessage() (string, error) {
+	if r.unavailable != nil {
+		return "", r.unavailable
+	}
+	if r.proxies != nil {
+		return "", errors.Wrap(ErrNoTagMessage, "tag messages cannot be read from module zips")
+	}
+	if r.foreign() {
+		return "", errors.Wrap(ErrNoTagMessage,
+			fmt.Sprintf("tag messages can only be read from git repositories, not %s", vcsName(r.newVCS)))
+	}
+	if !gitAvailable() {
+		return "", errNoGit
+	}
+	tag, _ := refFromVersion(r.newVersion)
+	if tag == "" {
+		return "", errors.Wrap(ErrNoTagMessage, fmt.Sprintf("%s is not a release version", r.newVersion))
+	}
+	candidates := []string{tag}
+	if prefix := r.newTagPrefix(); prefix != "" {
+		candidates = []string{prefix + tag, tag}
+	}
+	var out string
+	for _, candidate := range candidates {
+		var err error
+		out, err = gitOutput(r.forkRepoDir(), "for-each-ref",
+			"--format=%(objecttype) %(contents)",
+			fmt.Sprintf("refs/tags/%s", candidate),
+		)
+		if err != nil {
+			return "", err
+		}
+		if out != "" {
+			break
+		}
+	}
+	if out == "" {
+		return "", errors.Wrap(ErrNoTagMessage, fmt.Sprintf("no tag %s in %s", r.newVersion, r.newRepo))
+	}
+	if !strings.HasPrefix(out, "tag ") {
+		return "", errors.Wrap(ErrNoTagMessage, fmt.Sprintf("tag %s in %s is not annotated", r.newVersion, r.newRepo))
+	}
+	return strings.TrimPrefix(out, "tag "), nil
 }
 
 func (r *Repo) gitRefs() (string, string) {
-	oldRef := refFromVersion(r.oldVersion)
+	oldRef := r.versionRef(r.cachePath(r.oldRepo), r.oldTagPrefix(), r.oldVersion)
 	if oldRef == "" {
-		oldRef = "origin/master"
+		oldRef = r.upstreamBranch()
+	}
+	newRef := r.versionRef(r.forkRepoDir(), r.newTagPrefix(), r.newVersion)
+	if r.snapshot != "" {
+		newRef = r.snapshot
 	}
-	newRef := refFromVersion(r.newVersion)
 	if newRef == "" {
-		newRef = "remotes/replace/master"
+		newRef = r.forkBranchRef()
 	}
 	return oldRef, newRef
 }
 
+// upstreamBranch is the default branch of the upstream repository
+func (r *Repo) upstreamBranch() string {
+	if r.oldBranch != "" {
+		return r.oldBranch
+	}
+	return "origin/master"
+}
+
+// forkBranchRef is the branch of the fork compared when the new
+// version does not name a commit
+func (r *Repo) forkBranchRef() string {
+	switch {
+	case r.forkBranch != "":
+		return fmt.Sprintf("remotes/%s/%s", remoteName, r.forkBranch)
+	case r.newBranch != "":
+		return r.newBranch
+	}
+	return fmt.Sprintf("remotes/%s/master", remoteName)
+}
+
 func (r *Repo) gitRange() string {
 	oldRef, newRef := r.gitRefs()
 	result := fmt.Sprintf("%s..%s", oldRef, newRef)
@@ -253,25 +691,31 @@ func (r *Repo) gitRange() string {
 }
 
 func (r *Repo) commonAncestor() bool {
-	oldRef, newRef := r.gitRefs()
-	err := r.git(false, "merge-base", oldRef, newRef)
-	if err != nil {
-		return false
-	}
-	return true
+	return r.MergeBase() != ""
 }
 
 func (r *Repo) path() string {
-	parts := strings.SplitN(r.newPath, "/", 4)
-	if len(parts) > 3 {
-		return parts[3]
+	if r.localDir != "" {
+		return r.localSubdir
 	}
-	return ""
+	return r.newSubdir
 }
 
-// Log shows the simple log output between the two versions
+// Log shows the commits between the two versions, labeled with
+// whether they are already upstream, followed by how far the fork
+// has diverged
 func (r *Repo) Log() error {
 
+	if reason := r.Unavailable(); reason != "" {
+		fmt.Printf("Not logging, %s.\n", reason)
+		return nil
+	}
+
+	if reason := r.TreeDiff(); reason != "" {
+		fmt.Printf("Not logging, %s.\n", reason)
+		return nil
+	}
+
 	startEnd := r.gitRange()
 
 	if !r.commonAncestor() {
@@ -279,62 +723,265 @@ func (r *Repo) Log() error {
 		return nil
 	}
 
-	args := []string{
-		"log",
-		"--pretty=format:%h %cd %s",
-		"--date=iso",
-		"--decorate",
-		startEnd,
+	commits, err := r.Commits()
+	if err != nil {
+		return err
 	}
-	path := r.path()
-	if path != "" {
-		args = append(args, "--", path)
+	err = r.ClassifyCommits(commits)
+	if err != nil {
+		return err
 	}
 
-	return r.git(true, args...)
+	for _, c := range commits {
+		hash := c.Hash
+		if len(hash) > 12 {
+			hash = hash[:12]
+		}
+		label := ""
+		if c.Carry != "" {
+			label = fmt.Sprintf(" [%s]", c.Carry)
+		}
+		fmt.Printf("%s %s %s%s\n", hash,
+			c.Date.Format("2006-01-02 15:04:05 -0700"), c.Subject, label)
+	}
+	fmt.Printf("\n%s\n", Summarize(commits))
+
+	divergence, err := r.Divergence(commits)
+	if err != nil {
+		return err
+	}
+	if divergence != nil {
+		fmt.Printf("%s\n", divergence)
+	}
+	return nil
 }
 
 // DiffStat shows the diff statistics between the two versions
 func (r *Repo) DiffStat() error {
 
-	startEnd := r.gitRange()
+	if reason := r.Unavailable(); reason != "" {
+		fmt.Printf("Not diffing, %s.\n", reason)
+		return nil
+	}
 
-	if !r.commonAncestor() {
+	if !r.foreign() && !r.commonAncestor() {
+		startEnd := r.gitRange()
+		fmt.Printf("No common ancestor, not diffing %s.\n", startEnd)
+		return nil
+	}
+
+	stats, err := r.DiffStats()
+	if err != nil {
+		return err
+	}
+
+	writeDiffStat(os.Stdout, stats)
+	return nil
+}
+
+// writeDiffStat shows the diff statistics like git diff --stat
+func writeDiffStat(w io.Writer, stats []FileStat) {
+	nameWidth, numberWidth, graphWidth, maxChange := statLayout(stats)
+	added, deleted := 0, 0
+	for _, stat := range stats {
+		if stat.Binary {
+			fmt.Fprintf(w, " %-*s | %*s\n", nameWidth, stat.Path, numberWidth, "Bin")
+			continue
+		}
+		added += stat.Added
+		deleted += stat.Deleted
+		plus, minus := scaleStat(stat.Added, stat.Deleted, graphWidth, maxChange)
+		fmt.Fprintf(w, " %-*s | %*d %s%s\n", nameWidth, stat.Path, numberWidth, stat.Added+stat.Deleted,
+			strings.Repeat("+", plus), strings.Repeat("-", minus))
+	}
+	if len(stats) == 0 {
+		fmt.Fprintf(w, " 0 files changed\n")
+		return
+	}
+	fmt.Fprintf(w, " %d %s changed", len(stats), plural(len(stats), "file", "files"))
+	if added > 0 || deleted == 0 {
+		fmt.Fprintf(w, ", %d %s(+)", added, plural(added, "insertion", "insertions"))
+	}
+	if deleted > 0 || added == 0 {
+		fmt.Fprintf(w, ", %d %s(-)", deleted, plural(deleted, "deletion", "deletions"))
+	}
+	fmt.Fprintln(w)
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
+
+// statWidth is the number of columns the diff statistics fit in, as
+// for git diff --stat
+const statWidth = 80
+
+// statLayout returns the widths of the columns of the diff statistics
+// and the largest number of changed lines in one file, dividing the
+// columns between the names and the graph the way git does
+func statLayout(stats []FileStat) (nameWidth, numberWidth, graphWidth, maxChange int) {
+	binary := false
+	for _, stat := range stats {
+		if len(stat.Path) > nameWidth {
+			nameWidth = len(stat.Path)
+		}
+		if stat.Binary {
+			binary = true
+		} else if stat.Added+stat.Deleted > maxChange {
+			maxChange = stat.Added + stat.Deleted
+		}
+	}
+	numberWidth = len(strconv.Itoa(maxChange))
+	if binary && numberWidth < len("Bin") {
+		numberWidth = len("Bin")
+	}
+
+	graphWidth = maxChange
+	if nameWidth+numberWidth+6+graphWidth > statWidth {
+		if most := statWidth*3/8 - numberWidth - 6; graphWidth > most {
+			graphWidth = most
+			if graphWidth < 6 {
+				graphWidth = 6
+			}
+		}
+		if nameWidth <= statWidth-numberWidth-6-graphWidth {
+			graphWidth = statWidth - numberWidth - 6 - nameWidth
+		}
+	}
+	return nameWidth, numberWidth, graphWidth, maxChange
+}
+
+// scaleStat returns the number of characters used to show the added
+// and deleted lines of a file in the diff statistics. When the largest
+// change does not fit in the graph every count is scaled down, keeping
+// the proportion of added to deleted lines, as git does.
+func scaleStat(added, deleted, graphWidth, maxChange int) (int, int) {
+	if maxChange <= graphWidth {
+		return added, deleted
+	}
+	scale := func(n int) int {
+		if n == 0 {
+			return 0
+		}
+		return 1 + n*(graphWidth-1)/maxChange
+	}
+	total := scale(added + deleted)
+	if total < 2 && added > 0 && deleted > 0 {
+		total = 2
+	}
+	if added < deleted {
+		added = scale(added)
+		return added, total - added
+	}
+	deleted = scale(deleted)
+	return total - deleted, deleted
+}
+
+// Diff shows the full diff between the two versions
+func (r *Repo) Diff() error {
+
+	if reason := r.Unavailable(); reason != "" {
+		fmt.Printf("Not diffing, %s.\n", reason)
+		return nil
+	}
+
+	if !r.foreign() && !r.commonAncestor() {
+		startEnd := r.gitRange()
 		fmt.Printf("No common ancestor, not diffing %s.\n", startEnd)
 		return nil
 	}
 
-	args := []string{"diff", "--stat=80", r.gitRange(), "--"}
+	stats, err := r.diff(true)
+	if err != nil {
+		return err
+	}
+	for _, stat := range stats {
+		fmt.Print(stat.Patch)
+	}
+	return nil
+}
+
+// diffPathspec limits diffs to the module directory, or to everything
+// except the vendor directory for modules at the root of the repo
+func (r *Repo) diffPathspec() []string {
 	path := r.path()
 	if path != "" {
-		args = append(args, path)
-	} else {
-		args = append(args, ".", ":!vendor")
+		return []string{path}
 	}
-
-	return r.git(true, args...)
+	return []string{".", ":!vendor"}
 }
 
 func (r *Repo) git(verbose bool, args ...string) error {
 	return git(verbose, r.localPath, args...)
 }
 
-func resolveOne(importPath string) (string, error) {
+// gitLogged runs git in the local clone, labeling any verbose output
+// with the module path
+func (r *Repo) gitLogged(verbose bool, args ...string) error {
+	return runGit(verbose, r.oldPath, r.localPath, args...)
+}
+
+var (
+	resolveLock  sync.Mutex
+	resolveCache = map[string]*discovery.RepoRoot{}
+)
+
+// resolveOne finds the repository for the import path, remembering
+// the answer because several modules often share one repository
+func resolveOne(importPath string) (*discovery.RepoRoot, error) {
+	resolveLock.Lock()
+	root, ok := resolveCache[importPath]
+	resolveLock.Unlock()
+	if ok {
+		return root, nil
+	}
+
+	// Offline, only the answers from earlier runs can be used, apart
+	// from github paths which need no lookup.
+	if offline && !strings.HasPrefix(importPath, "github.com/") {
+		resolveLock.Lock()
+		root, ok = savedRoots[importPath]
+		resolveLock.Unlock()
+		if !ok {
+			return nil, errOffline
+		}
+		return root, nil
+	}
+
+	root, err := resolveUncached(importPath)
+	if err != nil {
+		return nil, err
+	}
+
+	resolveLock.Lock()
+	resolveCache[importPath] = root
+	resolveLock.Unlock()
+	return root, nil
+}
+
+func resolveUncached(importPath string) (*discovery.RepoRoot, error) {
 	if strings.HasPrefix(importPath, "github.com/") {
 		url, err := urlpkg.Parse(fmt.Sprintf("https://%s", importPath))
 		if err != nil {
-			return "", errors.Wrap(err, "could not parse github path")
+			return nil, errors.Wrap(err, "could not parse github path")
 		}
 		repoPath := strings.Split(url.Path, "/")
 		// The 0th element of repoPath is "" so to get the base path
 		// of the repo we join the first 3 elements to get /org/repo
 		url.Path = strings.Join(repoPath[:3], "/")
-		return url.String(), nil
+		return &discovery.RepoRoot{
+			Root:   url.String(),
+			Prefix: url.Host + url.Path,
+			VCS:    "git",
+		}, nil
 	}
 
-	repoRoot, err := discovery.RepoRootForImportDynamic(importPath)
+	repoRoot, err := discovery.RepoForImportDynamic(importPath)
 	if err != nil {
-		return "", errors.Wrap(err, "could not determine repo root")
+		return nil, errors.Wrap(err, "could not determine repo root")
 	}
 	return repoRoot, nil
 }
@@ -0,0 +1,146 @@
+package vcs
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestModuleSubdir(t *testing.T) {
+	for _, tc := range []struct {
+		modulePath string
+		prefix     string
+		dir        string
+		majorDir   string
+	}{
+		{"github.com/u/x", "github.com/u/x", "", ""},
+		{"github.com/u/x", "", "", ""},
+		{"github.com/u/x/sub", "github.com/u/x", "sub", "sub"},
+		{"github.com/u/x/v2", "github.com/u/x", "", "v2"},
+		{"github.com/u/x/sub/v3", "github.com/u/x", "sub", "sub/v3"},
+		{"github.com/u/x/a/b", "github.com/u/x", "a/b", "a/b"},
+		{"github.com/u/xy", "github.com/u/x", "", ""},
+		{"gopkg.in/yaml.v2", "gopkg.in/yaml.v2", "", ""},
+	} {
+		dir, majorDir := moduleSubdir(tc.modulePath, tc.prefix)
+		if dir != tc.dir || majorDir != tc.majorDir {
+			t.Errorf("moduleSubdir(%q, %q) = %q, %q, want %q, %q",
+				tc.modulePath, tc.prefix, dir, majorDir, tc.dir, tc.majorDir)
+		}
+	}
+}
+
+func TestFindMajorSubdir(t *testing.T) {
+	backend := fakeBackend{
+		refs: map[string]string{
+			"refs/tags/v2.0.0": "c1",
+			"refs/tags/v2.1.0": "c2",
+		},
+		files: map[string]map[string]string{
+			"c1": {"go.mod": "module github.com/f/x/v2\n"},
+			"c2": {"v2/go.mod": "module github.com/f/x/v2\n"},
+		},
+	}
+	for _, tc := range []struct {
+		version string
+		want    string
+	}{
+		{"v2.0.0", ""},
+		{"v2.1.0", "v2"},
+		{"v2.1.1-0.20200102030405-c2", "v2"},
+		{"v2.2.0", ""},
+	} {
+		r := &Repo{
+			newRepo:        "https://github.com/f/x",
+			newVersion:     tc.version,
+			newMajorSubdir: "v2",
+			backend:        backend,
+		}
+		r.findMajorSubdir()
+		if r.newSubdir != tc.want {
+			t.Errorf("%s: newSubdir = %q, want %q", tc.version, r.newSubdir, tc.want)
+		}
+	}
+}
+
+func TestLocalClonePath(t *testing.T) {
+	f := localClonePath("/work", "github.com/u/x", "github.com/f/x")
+	if f != localClonePath("/work", "github.com/u/x", "github.com/f/x") {
+		t.Errorf("the same fork gets different clones")
+	}
+	if f == localClonePath("/work", "github.com/u/x", "github.com/g/x") {
+		t.Errorf("different forks share the clone %s", f)
+	}
+	if filepath.Dir(f) != filepath.FromSlash("/work/github.com/u") {
+		t.Errorf("clone %s is not next to the module path", f)
+	}
+}
+
+func TestWriteDiffStat(t *testing.T) {
+	// The expected output is what git diff --stat=80 shows for the
+	// same changes, except for the sizes of binary files.
+	for _, tc := range []struct {
+		name  string
+		stats []FileStat
+		want  string
+	}{
+		{"nothing", nil, " 0 files changed\n"},
+		{
+			"one line",
+			[]FileStat{{Path: "a.go", Added: 1}},
+			" a.go | 1 +\n 1 file changed, 1 insertion(+)\n",
+		},
+		{
+			"fits",
+			[]FileStat{{Path: "mixed.go", Added: 41, Deleted: 40}, {Path: "small.go", Added: 31}},
+			" mixed.go | 81 ++++++++++++++++++++++++++++++++--------------------------------\n" +
+				" small.go | 31 +++++++++++++++++++++++++\n" +
+				" 2 files changed, 72 insertions(+), 40 deletions(-)\n",
+		},
+		{
+			"scaled",
+			[]FileStat{
+				{Path: "a_rather_long_directory_name_here/x.go", Added: 3},
+				{Path: "big.go", Added: 5000},
+				{Path: "mixed.go", Added: 41, Deleted: 40},
+				{Path: "small.go", Added: 31},
+			},
+			" a_rather_long_directory_name_here/x.go |    3 +\n" +
+				" big.go                                 | 5000 ++++++++++++++++++++++++++++++++\n" +
+				" mixed.go                               |   81 +-\n" +
+				" small.go                               |   31 +\n" +
+				" 4 files changed, 5075 insertions(+), 40 deletions(-)\n",
+		},
+		{
+			"binary",
+			[]FileStat{{Path: "bin.dat", Binary: true}, {Path: "del.go", Deleted: 1}},
+			" bin.dat | Bin\n del.go  |   1 -\n 2 files changed, 1 deletion(-)\n",
+		},
+	} {
+		var out strings.Builder
+		writeDiffStat(&out, tc.stats)
+		if out.String() != tc.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tc.name, out.String(), tc.want)
+		}
+	}
+}
+
+func TestScaleStat(t *testing.T) {
+	for _, tc := range []struct {
+		added, deleted, graphWidth, maxChange int
+		plus, minus                           int
+	}{
+		{10, 5, 50, 15, 10, 5},
+		{31, 0, 20, 5000, 1, 0},
+		{5000, 0, 20, 5000, 20, 0},
+		{2500, 2500, 20, 5000, 10, 10},
+		{1, 1, 20, 5000, 1, 1},
+		{100, 4000, 20, 5000, 1, 15},
+	} {
+		plus, minus := scaleStat(tc.added, tc.deleted, tc.graphWidth, tc.maxChange)
+		if plus != tc.plus || minus != tc.minus {
+			t.Errorf("scaleStat(%d, %d, %d, %d) = %d, %d, want %d, %d",
+				tc.added, tc.deleted, tc.graphWidth, tc.maxChange, plus, minus, tc.plus, tc.minus)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package vcs
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// incompatible is the build suffix for major versions above v1 of
+// modules without the major version in their path
+const incompatible = "+incompatible"
+
+// refFromVersion returns the tag or commit a module version refers
+// to. Releases and pre-releases name a tag, pseudo-versions name a
+// commit, and anything that is not a semantic version is assumed to
+// be a git revision already. It returns empty strings for versions
+// that do not refer to anything.
+func refFromVersion(version string) (tag string, commit string) {
+	if version == "" || version == "v0.0.0" {
+		return "", ""
+	}
+
+	if !semver.IsValid(version) {
+		return "", version
+	}
+
+	// The tag for v2.0.0+incompatible is v2.0.0.
+	version = strings.TrimSuffix(version, incompatible)
+
+	if module.IsPseudoVersion(version) {
+		rev, err := module.PseudoVersionRev(version)
+		if err != nil || strings.Trim(rev, "0") == "" {
+			return "", ""
+		}
+		return "", rev
+	}
+
+	return version, ""
+}
+
+// versionRef returns the revision in the local clone for the version
+// of the module in the repository in repoDir. Tags are looked up in
+// repoDir, rather than the local clone, because the local clone holds
+// the tags of both repositories. A module in a subdirectory of the
+// repository uses tags starting with the directory, so those are tried
+// before the plain tag. It returns an empty string if the version does
+// not name a revision.
+func (r *Repo) versionRef(repoDir, tagPrefix, version string) string {
+	tag, commit := refFromVersion(version)
+	if tag == "" {
+		return commit
+	}
+
+	candidates := []string{tag}
+	if tagPrefix != "" {
+		candidates = []string{tagPrefix + tag, tag}
+	}
+	for _, candidate := range candidates {
+		hash, err := r.backend.RevParse(repoDir, fmt.Sprintf("refs/tags/%s", candidate))
+		if err == nil {
+			return hash
+		}
+	}
+	// Let git report the missing tag when it is used.
+	return fmt.Sprintf("refs/tags/%s", candidates[0])
+}
+
+// moduleTagPrefix returns the prefix for the tags of a module in the
+// directory of a repository. The major version suffix of the module
+// path is not part of the prefix.
+func moduleTagPrefix(dir string) string {
+	dir = stripMajorVersion(dir)
+	if dir == "" {
+		return ""
+	}
+	return dir + "/"
+}
+
+// oldTagPrefix returns the tag prefix for the old module. When the
+// upstream repository comes from an alias the module is in the same
+// directory as in the fork.
+func (r *Repo) oldTagPrefix() string {
+	if r.aliased != "" {
+		return moduleTagPrefix(r.path())
+	}
+	return moduleTagPrefix(r.oldSubdir)
+}
+
+// newTagPrefix returns the tag prefix for the module in the fork
+func (r *Repo) newTagPrefix() string {
+	return moduleTagPrefix(r.path())
+}
+
+// CheckVersions compares the old and new versions with the module
+// paths and the commits they resolve to. It returns a description of
+// each problem found.
+func (r *Repo) CheckVersions() []string {
+	problems := []string{}
+	problems = append(problems, r.checkVersion(r.oldPath, r.oldVersion)...)
+	if r.localDir == "" {
+		problems = append(problems, r.checkVersion(r.newPath, r.newVersion)...)
+	}
+	return problems
+}
+
+func (r *Repo) checkVersion(modulePath, version string) []string {
+	if !semver.IsValid(version) {
+		return nil
+	}
+
+	problems := []string{}
+
+	_, pathMajor, ok := module.SplitPathVersion(modulePath)
+	if ok {
+		err := module.CheckPathMajor(version, pathMajor)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", modulePath, err))
+		}
+	}
+
+	if !module.IsPseudoVersion(version) || !r.HasGitHistory() {
+		return problems
+	}
+	_, commit := refFromVersion(version)
+	if commit == "" {
+		return problems
+	}
+	expected, err := module.PseudoVersionTime(version)
+	if err != nil {
+		return append(problems, fmt.Sprintf("%s@%s: %s", modulePath, version, err))
+	}
+	out, err := r.gitOutput("log", "-1", "--pretty=format:%cI", commit)
+	if err != nil {
+		return append(problems, fmt.Sprintf("%s@%s: commit %s not found", modulePath, version, commit))
+	}
+	actual, err := time.Parse(time.RFC3339, strings.TrimSpace(out))
+	if err != nil {
+		return append(problems, fmt.Sprintf("%s@%s: could not parse date of %s", modulePath, version, commit))
+	}
+	if !actual.UTC().Equal(expected) {
+		problems = append(problems, fmt.Sprintf(
+			"%s@%s: commit %s is from %s but the pseudo-version says %s",
+			modulePath, version, commit,
+			actual.UTC().Format(time.RFC3339), expected.Format(time.RFC3339)))
+	}
+	return problems
+}
@@ -0,0 +1,29 @@
+package vcs
+
+import "testing"
+
+func TestRefFromVersion(t *testing.T) {
+	for _, tc := range []struct {
+		version string
+		tag     string
+		commit  string
+	}{
+		{"", "", ""},
+		{"v0.0.0", "", ""},
+		{"v1.2.3", "v1.2.3", ""},
+		{"v1.2.3-rc.1", "v1.2.3-rc.1", ""},
+		{"v2.0.0+incompatible", "v2.0.0", ""},
+		{"v0.0.0-20200102030405-0123456789ab", "", "0123456789ab"},
+		{"v1.2.4-0.20200102030405-0123456789ab", "", "0123456789ab"},
+		{"v2.0.1-0.20200102030405-0123456789ab+incompatible", "", "0123456789ab"},
+		{"v0.0.0-00010101000000-000000000000", "", ""},
+		{"main", "", "main"},
+		{"0123456789ab", "", "0123456789ab"},
+	} {
+		tag, commit := refFromVersion(tc.version)
+		if tag != tc.tag || commit != tc.commit {
+			t.Errorf("refFromVersion(%q) = %q, %q, want %q, %q",
+				tc.version, tag, commit, tc.tag, tc.commit)
+		}
+	}
+}